@@ -0,0 +1,205 @@
+package main
+
+import (
+	"encoding/base64"
+	"encoding/json"
+	"flag"
+	"fmt"
+	"io"
+	"log"
+	"os"
+	"time"
+
+	"github.com/golang-jwt/jwt"
+	"github.com/shinhagunn/websocket/pkg/auth"
+)
+
+const usage = `Manage the keys and tokens of rango
+Usage:
+  rango-keys generate -alg=ES256 -private=key.pem -public=key.pub
+  rango-keys pubkey   -private=key.pem | -public=key.pub
+  rango-keys forge    -private=key.pem -uid=UID123 -role=admin -level=3 -expire=1h -claims='{"state":"active"}'
+  rango-keys verify   -token=$JWT [-key=$JWT_PUBLIC_KEY | -public=key.pub | -jwks=jwks.json]
+Run a command with -h for its flags.
+`
+
+func exit(format string, a ...interface{}) {
+	log.Printf(format, a...)
+	os.Exit(1)
+}
+
+func newFlagSet(name string) *flag.FlagSet {
+	return flag.NewFlagSet("rango-keys "+name, flag.ExitOnError)
+}
+
+// printPublicKey prints the public key in the JWT_PUBLIC_KEY format.
+func printPublicKey(ks *auth.KeyStore) {
+	key, err := ks.PublicKeyPEM()
+	if err != nil {
+		exit("Failed to encode public key: %v", err)
+	}
+
+	fmt.Println(base64.StdEncoding.EncodeToString(key))
+}
+
+func generate(args []string) {
+	fs := newFlagSet("generate")
+	alg := fs.String("alg", "RS256", "RS256, ES256, ES384 or EdDSA")
+	priv := fs.String("private", "key.pem", "private key file to write")
+	pub := fs.String("public", "key.pub", "public key file to write")
+	force := fs.Bool("force", false, "overwrite existing files")
+	fs.Parse(args)
+
+	for _, path := range []string{*priv, *pub} {
+		if _, err := os.Stat(path); err == nil && !*force {
+			exit("%s exists, use -force to overwrite it", path)
+		}
+	}
+
+	ks := &auth.KeyStore{Algorithm: *alg}
+	if err := ks.GenerateKeys(); err != nil {
+		exit("Failed to generate keys: %v", err)
+	}
+
+	if err := ks.SavePrivateKey(*priv); err != nil {
+		exit("Failed to save private key: %v", err)
+	}
+
+	if err := ks.SavePublicKey(*pub); err != nil {
+		exit("Failed to save public key: %v", err)
+	}
+
+	printPublicKey(ks)
+}
+
+func pubkey(args []string) {
+	fs := newFlagSet("pubkey")
+	priv := fs.String("private", "", "private key file")
+	pub := fs.String("public", "", "public key file")
+	fs.Parse(args)
+
+	ks := &auth.KeyStore{}
+	switch {
+	case *pub != "":
+		if err := ks.LoadPublicKeyFromFile(*pub); err != nil {
+			exit("Failed to load public key: %v", err)
+		}
+	case *priv != "":
+		if err := ks.LoadPrivateKey(*priv); err != nil {
+			exit("Failed to load private key: %v", err)
+		}
+		ks.PublicKey = ks.PrivateKey.Public()
+	default:
+		fs.Usage()
+		os.Exit(2)
+	}
+
+	printPublicKey(ks)
+}
+
+func forge(args []string) {
+	fs := newFlagSet("forge")
+	priv := fs.String("private", "key.pem", "private key file to sign with")
+	uid := fs.String("uid", "UID123", "uid claim")
+	email := fs.String("email", "", "email claim")
+	role := fs.String("role", "member", "role claim")
+	level := fs.Int("level", 3, "level claim")
+	expire := fs.Duration("expire", time.Hour, "token lifetime")
+	claims := fs.String("claims", "", "JSON object of claims added or overridden")
+	fs.Parse(args)
+
+	ks := &auth.KeyStore{}
+	if err := ks.LoadPrivateKey(*priv); err != nil {
+		exit("Failed to load private key: %v", err)
+	}
+
+	custom := jwt.MapClaims{}
+	if *claims != "" {
+		if err := json.Unmarshal([]byte(*claims), &custom); err != nil {
+			exit("Invalid claims: %v", err)
+		}
+	}
+
+	if _, ok := custom["exp"]; !ok {
+		custom["exp"] = time.Now().Add(*expire).Unix()
+	}
+
+	token, err := auth.ForgeToken(*uid, *email, *role, *level, ks.PrivateKey, custom)
+	if err != nil {
+		exit("Failed to forge token: %v", err)
+	}
+
+	fmt.Println(token)
+}
+
+func verify(args []string) {
+	fs := newFlagSet("verify")
+	token := fs.String("token", "", "JWT to verify")
+	key := fs.String("key", "", "base64 PEM public key, like JWT_PUBLIC_KEY")
+	pub := fs.String("public", "", "public key file")
+	jwks := fs.String("jwks", "", "JSON web key set file or URL")
+	leeway := fs.Duration("leeway", 0, "clock skew tolerated on time claims")
+	fs.Parse(args)
+
+	if *token == "" {
+		fs.Usage()
+		os.Exit(2)
+	}
+
+	var keys *auth.KeySet
+	var err error
+
+	ks := &auth.KeyStore{}
+	switch {
+	case *jwks != "":
+		keys, err = auth.NewJWKSKeySet(*jwks)
+	case *key != "":
+		err = ks.LoadPublicKeyFromString(*key)
+	case *pub != "":
+		err = ks.LoadPublicKeyFromFile(*pub)
+	}
+	if err != nil {
+		exit("Failed to load key: %v", err)
+	}
+
+	if keys == nil && ks.PublicKey != nil {
+		keys = auth.NewKeySet()
+		keys.Add("", ks.PublicKey)
+	}
+
+	claims := auth.Auth{}
+	if keys == nil {
+		log.Println("No key given, the token signature is not verified")
+		_, _, err = new(jwt.Parser).ParseUnverified(*token, &claims)
+	} else {
+		claims, err = auth.ParseWithPolicy(*token, keys, auth.ClaimPolicy{Leeway: *leeway})
+	}
+
+	out, _ := json.MarshalIndent(claims, "", "  ")
+	fmt.Println(string(out))
+
+	if err != nil {
+		exit("Invalid token: %v", err)
+	}
+}
+
+func main() {
+	if len(os.Args) < 2 {
+		io.WriteString(os.Stderr, usage)
+		os.Exit(2)
+	}
+
+	switch os.Args[1] {
+	case "generate":
+		generate(os.Args[2:])
+	case "pubkey":
+		pubkey(os.Args[2:])
+	case "forge":
+		forge(os.Args[2:])
+	case "verify":
+		verify(os.Args[2:])
+	default:
+		io.WriteString(os.Stderr, usage)
+		os.Exit(2)
+	}
+}
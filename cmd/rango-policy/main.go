@@ -0,0 +1,134 @@
+package main
+
+import (
+	"flag"
+	"fmt"
+	"io"
+	"log"
+	"os"
+	"strconv"
+	"strings"
+
+	"github.com/golang-jwt/jwt"
+	"github.com/shinhagunn/websocket/config"
+	"github.com/shinhagunn/websocket/pkg/auth"
+	"github.com/shinhagunn/websocket/pkg/policy"
+)
+
+var (
+	policyFile = flag.String("policy", "", "policy file, RANGO_RBAC_* lists when empty")
+	token      = flag.String("token", "", "JWT to evaluate")
+	key        = flag.String("key", "", "base64 PEM public key to verify the token with, like JWT_PUBLIC_KEY")
+	uid        = flag.String("uid", "", "uid, overrides the token one")
+	role       = flag.String("role", "", "role, overrides the token one")
+	level      = flag.Int("level", -1, "level, overrides the token one")
+	streams    = flag.String("streams", "", "comma separated streams to evaluate")
+)
+
+func loadPolicy(conf *config.Config) (*policy.Policy, error) {
+	if *policyFile != "" {
+		return policy.LoadFile(*policyFile)
+	}
+
+	return policy.FromRBAC(conf.Rango.RbacAdmin, conf.Rango.RbacSystem, conf.Rango.PrivateMinLevel), nil
+}
+
+func parseToken() (auth.Auth, error) {
+	if *key == "" {
+		log.Println("No key given, the token signature is not verified")
+
+		claims := auth.Auth{}
+		_, _, err := new(jwt.Parser).ParseUnverified(*token, &claims)
+		return claims, err
+	}
+
+	ks := auth.KeyStore{}
+	if err := ks.LoadPublicKeyFromString(*key); err != nil {
+		return auth.Auth{}, err
+	}
+
+	keys := auth.NewKeySet()
+	keys.Add("", ks.PublicKey)
+
+	return auth.ParseAndValidateKeySet(*token, keys)
+}
+
+func subject() (policy.Subject, error) {
+	s := policy.Subject{}
+
+	if *token != "" {
+		claims, err := parseToken()
+		if err != nil {
+			return s, err
+		}
+
+		s.UID = claims.UID
+		s.Role = claims.Role
+		if l, err := strconv.Atoi(claims.Level.String()); err == nil {
+			s.Level = l
+		}
+	}
+
+	if *uid != "" {
+		s.UID = *uid
+	}
+	if *role != "" {
+		s.Role = *role
+	}
+	if *level >= 0 {
+		s.Level = *level
+	}
+
+	return s, nil
+}
+
+func main() {
+	flag.Usage = func() {
+		io.WriteString(os.Stderr, `Dry-run a token against the stream authorization policy
+Example usage: ./rango-policy -policy=policy.json -token=$JWT -streams=btcusd.trades,admin.btcusd.orders
+`)
+		flag.PrintDefaults()
+	}
+	flag.Parse()
+
+	if *streams == "" {
+		flag.Usage()
+		os.Exit(2)
+	}
+
+	conf, err := config.NewConfig()
+	if err != nil {
+		log.Fatalf("Failed to load config: %v", err)
+	}
+
+	p, err := loadPolicy(conf)
+	if err != nil {
+		log.Fatalf("Failed to load policy: %v", err)
+	}
+
+	s, err := subject()
+	if err != nil {
+		log.Fatalf("Invalid token: %v", err)
+	}
+
+	fmt.Printf("uid=%q role=%q level=%d\n", s.UID, s.Role, s.Level)
+
+	denied := false
+	for _, stream := range strings.Split(*streams, ",") {
+		stream = strings.TrimSpace(stream)
+		// RANGO_PRIVATE_MIN_LEVEL applies with any policy, as on the server
+		d := policy.Authorize(p, s, stream, conf.Rango.PrivateMinLevel)
+
+		effect := policy.EffectAllow
+		if !d.Allowed {
+			effect = policy.EffectDeny
+			denied = true
+		}
+
+		fmt.Printf("%-5s %-8s %s (%s)\n", effect, policy.StreamScope(stream), stream, d.Reason)
+	}
+
+	if denied {
+		os.Exit(1)
+	}
+}
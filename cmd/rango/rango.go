@@ -39,6 +39,9 @@ func main() {
 	}
 
 	go epoll.Read()
+	go epoll.WatchExpiry()
+	go epoll.ReapTopics()
+	go epoll.WatchPolicy(config.Rango.PolicyReload)
 
 	for i := 0; i < numberOfWorker; i++ {
 		go epoll.Write()
@@ -1,6 +1,8 @@
 package config
 
 import (
+	"time"
+
 	"github.com/caarlos0/env"
 	"github.com/cockroachdb/errors"
 	"github.com/zsmartex/pkg/v2/config"
@@ -9,6 +11,109 @@ import (
 type Rango struct {
 	RbacSystem []string `env:"RANGO_RBAC_SYSTEM" envDefault:"admin,superadmin,operator"`
 	RbacAdmin  []string `env:"RANGO_RBAC_ADMIN" envDefault:"admin,superadmin"`
+
+	// JSON stream authorization policy, replacing the RBAC lists when set
+	PolicyFile   string        `env:"RANGO_POLICY_FILE"`
+	PolicyReload time.Duration `env:"RANGO_POLICY_RELOAD" envDefault:"10s"`
+
+	// Origins allowed on /public and /private, API_CORS_ORIGINS when empty
+	PublicOrigins  []string `env:"RANGO_PUBLIC_ORIGINS"`
+	PrivateOrigins []string `env:"RANGO_PRIVATE_ORIGINS"`
+
+	// Proxies whose X-Forwarded-For is trusted, as CIDRs or IPs
+	TrustedProxies []string `env:"RANGO_TRUSTED_PROXIES"`
+	// Client networks allowed and denied per endpoint, every network is
+	// allowed when the allow list is empty
+	PublicAllowIPs  []string `env:"RANGO_PUBLIC_ALLOW_IPS"`
+	PublicDenyIPs   []string `env:"RANGO_PUBLIC_DENY_IPS"`
+	PrivateAllowIPs []string `env:"RANGO_PRIVATE_ALLOW_IPS"`
+	PrivateDenyIPs  []string `env:"RANGO_PRIVATE_DENY_IPS"`
+	AdminAllowIPs   []string `env:"RANGO_ADMIN_ALLOW_IPS"`
+	AdminDenyIPs    []string `env:"RANGO_ADMIN_DENY_IPS"`
+
+	// Certificate and key served over TLS, plain HTTP when empty
+	TLSCertFile string        `env:"RANGO_TLS_CERT_FILE"`
+	TLSKeyFile  string        `env:"RANGO_TLS_KEY_FILE"`
+	TLSReload   time.Duration `env:"RANGO_TLS_RELOAD" envDefault:"1m"`
+
+	// CA bundle of the client certificates accepted on /mtls, disabled when empty
+	MTLSClientCA string `env:"RANGO_MTLS_CLIENT_CA"`
+	// Certificate field holding the uid: cn, email, uri or dns
+	MTLSIdentity string `env:"RANGO_MTLS_IDENTITY" envDefault:"cn"`
+	// Role and level of certificates, the subject OU overriding the role
+	MTLSRole  string `env:"RANGO_MTLS_ROLE" envDefault:"service"`
+	MTLSLevel int    `env:"RANGO_MTLS_LEVEL" envDefault:"0"`
+
+	EnableCompression bool `env:"RANGO_ENABLE_COMPRESSION" envDefault:"false"`
+
+	// How long a stream without subscribers keeps its recent events for
+	// resuming clients, dropped with its last subscriber when 0
+	TopicRetention time.Duration `env:"RANGO_TOPIC_RETENTION" envDefault:"5m"`
+
+	// Epoll instances reading the websockets, each in its own goroutine,
+	// GOMAXPROCS when 0
+	EpollLoops int `env:"RANGO_EPOLL_LOOPS" envDefault:"0"`
+
+	// Time before a token expires at which the client is warned
+	TokenExpiryWarning time.Duration `env:"RANGO_TOKEN_EXPIRY_WARNING" envDefault:"1m"`
+	// What happens to a socket whose token expired: close or downgrade
+	TokenExpiryAction string `env:"RANGO_TOKEN_EXPIRY_ACTION" envDefault:"close"`
+
+	// Minimum token level required for private streams
+	PrivateMinLevel int `env:"RANGO_PRIVATE_MIN_LEVEL" envDefault:"0"`
+
+	// Authentication methods tried in order: bearer, query, cookie and hmac
+	AuthMethods []string `env:"RANGO_AUTH_METHODS" envDefault:"hmac,bearer"`
+	// Methods of given routes, as route:method|method
+	RouteAuthMethods []string `env:"RANGO_ROUTE_AUTH_METHODS"`
+	// Query parameter and cookie holding the token
+	AuthQueryParam string `env:"RANGO_AUTH_QUERY_PARAM" envDefault:"token"`
+	AuthCookie     string `env:"RANGO_AUTH_COOKIE" envDefault:"session"`
+
+	// JSON file of API keys accepted with HMAC signed upgrade requests
+	APIKeysFile string `env:"RANGO_API_KEYS_FILE"`
+	// Maximum difference between the HMAC nonce and the server time
+	HMACNonceWindow time.Duration `env:"RANGO_HMAC_NONCE_WINDOW" envDefault:"30s"`
+
+	// How long a revocation without end lasts
+	RevocationTTL time.Duration `env:"RANGO_REVOCATION_TTL" envDefault:"24h"`
+	// Kafka cluster and topic revocations are consumed from, disabled when
+	// the topic is empty
+	RevocationKafkaBrokers []string `env:"RANGO_REVOCATION_KAFKA_BROKERS" envDefault:"localhost:9092"`
+	RevocationKafkaTopic   string   `env:"RANGO_REVOCATION_KAFKA_TOPIC"`
+
+	// Where audit records go: file or kafka, disabled when empty
+	AuditSink string `env:"RANGO_AUDIT_SINK"`
+	// Audit log file, rotated at the size in megabytes keeping the backups
+	AuditFile       string `env:"RANGO_AUDIT_FILE" envDefault:"audit.log"`
+	AuditMaxSize    int    `env:"RANGO_AUDIT_MAX_SIZE" envDefault:"100"`
+	AuditMaxBackups int    `env:"RANGO_AUDIT_MAX_BACKUPS" envDefault:"5"`
+	// Kafka cluster and topic receiving audit records
+	AuditKafkaBrokers []string `env:"RANGO_AUDIT_KAFKA_BROKERS" envDefault:"localhost:9092"`
+	AuditKafkaTopic   string   `env:"RANGO_AUDIT_KAFKA_TOPIC" envDefault:"rango.audit"`
+
+	// Maximum open connections per user and per IP, unlimited when 0
+	MaxConnectionsPerUID int `env:"RANGO_MAX_CONNECTIONS_PER_UID" envDefault:"0"`
+	MaxConnectionsPerIP  int `env:"RANGO_MAX_CONNECTIONS_PER_IP" envDefault:"0"`
+	// Per user limits of given roles, as role:limit
+	MaxConnectionsPerRole []string `env:"RANGO_MAX_CONNECTIONS_PER_ROLE"`
+	// What happens to a connection over a limit: reject or evict_oldest
+	ConnectionLimitAction string `env:"RANGO_CONNECTION_LIMIT_ACTION" envDefault:"reject"`
+
+	// Outbound messages queued per connection, and what happens to a
+	// connection whose queue is full: drop_oldest, drop_newest, conflate
+	// (replace the queued event of the same stream) or disconnect
+	ClientQueueSize    int    `env:"RANGO_CLIENT_QUEUE_SIZE" envDefault:"256"`
+	SlowConsumerPolicy string `env:"RANGO_SLOW_CONSUMER_POLICY" envDefault:"drop_oldest"`
+
+	// Inbound messages per second and burst per connection, unlimited when 0
+	MessageRate  float64 `env:"RANGO_MESSAGE_RATE" envDefault:"0"`
+	MessageBurst int     `env:"RANGO_MESSAGE_BURST" envDefault:"20"`
+	// Streams subscribed or unsubscribed per second and burst per connection
+	SubscriptionRate  float64 `env:"RANGO_SUBSCRIPTION_RATE" envDefault:"0"`
+	SubscriptionBurst int     `env:"RANGO_SUBSCRIPTION_BURST" envDefault:"50"`
+	// What happens to a message over a rate: reject, throttle or disconnect
+	RateLimitAction string `env:"RANGO_RATE_LIMIT_ACTION" envDefault:"reject"`
 }
 
 type Config struct {
@@ -16,7 +121,26 @@ type Config struct {
 	Kafka           config.Kafka
 	Rango           Rango
 	ApplicationName string `env:"APP_NAME" envDefault:"Rango"`
-	JWTPublicKey    string `env:"JWT_PUBLIC_KEY"`
+
+	// Origins allowed to open websockets, as URLs or hosts, *.domain allowing
+	// its subdomains. Every origin is allowed when empty.
+	CORSOrigins []string `env:"API_CORS_ORIGINS"`
+
+	JWTPublicKey string `env:"JWT_PUBLIC_KEY"`
+
+	// Algorithms accepted for JWT_PUBLIC_KEY, the ones matching its type when empty
+	JWTAlgorithms []string `env:"JWT_ALGORITHMS"`
+
+	// JSON web key set to verify tokens with, as a local file or an http(s) URL
+	JWTJWKSFile    string        `env:"JWT_JWKS_FILE"`
+	JWTJWKSURL     string        `env:"JWT_JWKS_URL"`
+	JWTJWKSRefresh time.Duration `env:"JWT_JWKS_REFRESH" envDefault:"5m"`
+
+	// Claims tokens must carry, checks are skipped when empty
+	JWTAudience      []string      `env:"JWT_AUDIENCE"`
+	JWTIssuers       []string      `env:"JWT_ISSUERS"`
+	JWTRequireActive bool          `env:"JWT_REQUIRE_ACTIVE" envDefault:"false"`
+	JWTLeeway        time.Duration `env:"JWT_LEEWAY" envDefault:"0s"`
 }
 
 func NewConfig() (*Config, error) {
@@ -26,5 +150,10 @@ func NewConfig() (*Config, error) {
 		return nil, errors.Newf("parse config: %v", err)
 	}
 
+	// Nested structs are only parsed through pointers
+	if err := env.Parse(&conf.Rango); err != nil {
+		return nil, errors.Newf("parse config: %v", err)
+	}
+
 	return conf, nil
 }
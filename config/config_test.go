@@ -0,0 +1,23 @@
+package config
+
+import (
+	"testing"
+	"time"
+)
+
+func TestNewConfigRangoDefaults(t *testing.T) {
+	t.Setenv("RANGO_TOKEN_EXPIRY_ACTION", "downgrade")
+
+	conf, err := NewConfig()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if conf.Rango.TokenExpiryWarning != time.Minute || len(conf.Rango.RbacAdmin) != 2 {
+		t.Errorf("defaults not applied: %+v", conf.Rango)
+	}
+
+	if conf.Rango.TokenExpiryAction != "downgrade" {
+		t.Errorf("RANGO_TOKEN_EXPIRY_ACTION = %q, want downgrade", conf.Rango.TokenExpiryAction)
+	}
+}
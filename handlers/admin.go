@@ -0,0 +1,159 @@
+package handlers
+
+import (
+	"encoding/json"
+	"net/http"
+	"time"
+
+	"github.com/shinhagunn/websocket/config"
+	"github.com/shinhagunn/websocket/pkg/audit"
+	"github.com/shinhagunn/websocket/pkg/auth"
+	"github.com/shinhagunn/websocket/pkg/routing"
+)
+
+// adminOnly lets through requests authenticated by authHandler with one of
+// the RANGO_RBAC_ADMIN roles.
+func adminOnly(h http.HandlerFunc, config *config.Config) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		role := requestAuth(r).Role
+		for _, admin := range config.Rango.RbacAdmin {
+			if role == admin {
+				h(w, r)
+				return
+			}
+		}
+
+		w.WriteHeader(http.StatusForbidden)
+	}
+}
+
+// statusRecorder keeps the status written to the response.
+type statusRecorder struct {
+	http.ResponseWriter
+	status int
+}
+
+func (w *statusRecorder) WriteHeader(status int) {
+	w.status = status
+	w.ResponseWriter.WriteHeader(status)
+}
+
+// auditHandler records the calls to h with their outcome. It wraps
+// authHandler so refused calls are recorded too, with the identity it set.
+func auditHandler(h http.HandlerFunc, logger audit.Logger) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		rec := &statusRecorder{ResponseWriter: w, status: http.StatusOK}
+		h(rec, r)
+
+		id := requestAuth(r)
+		logger.Log(audit.Record{
+			Time:   time.Now(),
+			Event:  audit.EventAdmin,
+			UID:    id.UID,
+			Role:   id.Role,
+			IP:     requestIP(r),
+			Method: r.Method,
+			Path:   r.URL.Path,
+			Status: rec.status,
+		})
+	}
+}
+
+// revokeHandler revokes a token or a user and closes their connections.
+func revokeHandler(epoll *routing.Epoll) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		if r.Method != http.MethodPost {
+			w.WriteHeader(http.StatusMethodNotAllowed)
+			return
+		}
+
+		var revocation auth.Revocation
+		if err := json.NewDecoder(r.Body).Decode(&revocation); err != nil {
+			writeJSON(w, http.StatusBadRequest, map[string]string{"error": "invalid revocation"})
+			return
+		}
+
+		closed, err := epoll.Revoke(revocation)
+		if err != nil {
+			writeJSON(w, http.StatusBadRequest, map[string]string{"error": err.Error()})
+			return
+		}
+
+		writeJSON(w, http.StatusOK, map[string]int{"closed": closed})
+	}
+}
+
+// connectionsHandler lists the websocket, server-sent events and long-polling
+// connections, optionally only those of the uid or ip query parameters.
+func connectionsHandler(epoll *routing.Epoll) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		if r.Method != http.MethodGet {
+			w.WriteHeader(http.StatusMethodNotAllowed)
+			return
+		}
+
+		uid := r.URL.Query().Get("uid")
+		ip := r.URL.Query().Get("ip")
+
+		connections := []map[string]interface{}{}
+		for _, client := range epoll.Clients() {
+			auth := client.GetAuth()
+			if (uid != "" && auth.UID != uid) || (ip != "" && client.IP != ip) {
+				continue
+			}
+
+			queued, dropped := client.Queued()
+			connections = append(connections, map[string]interface{}{
+				"uid":          auth.UID,
+				"role":         auth.Role,
+				"ip":           client.IP,
+				"streams":      client.GetSubscriptions(),
+				"rate_limited": client.RateLimited(),
+				"queued":       queued,
+				"dropped":      dropped,
+			})
+		}
+
+		writeJSON(w, http.StatusOK, connections)
+	}
+}
+
+// statsHandler reports the topic, outbound queue and rate limit counters.
+func statsHandler(epoll *routing.Epoll) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		if r.Method != http.MethodGet {
+			w.WriteHeader(http.StatusMethodNotAllowed)
+			return
+		}
+
+		queued := 0
+		clients := epoll.Clients()
+		for _, client := range clients {
+			n, _ := client.Queued()
+			queued += n
+		}
+
+		public, prefixed, private := epoll.Topics()
+
+		writeJSON(w, http.StatusOK, map[string]interface{}{
+			"connections": len(clients),
+			"topics": map[string]int{
+				"public":   public,
+				"prefixed": prefixed,
+				"private":  private,
+			},
+			"queues": map[string]interface{}{
+				"pending":      queued,
+				"queued":       epoll.Queues.Queued.Load(),
+				"dropped":      epoll.Queues.Dropped.Load(),
+				"conflated":    epoll.Queues.Conflated.Load(),
+				"disconnected": epoll.Queues.Disconnected.Load(),
+			},
+			"rate_limits": map[string]interface{}{
+				"rejected":     epoll.RateLimits.Rejected.Load(),
+				"throttled":    epoll.RateLimits.Throttled.Load(),
+				"disconnected": epoll.RateLimits.Disconnected.Load(),
+			},
+		})
+	}
+}
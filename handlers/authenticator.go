@@ -0,0 +1,182 @@
+package handlers
+
+import (
+	"errors"
+	"fmt"
+	"net/http"
+	"strings"
+
+	"github.com/shinhagunn/websocket/config"
+	"github.com/shinhagunn/websocket/pkg/auth"
+)
+
+// Authentication methods, see RANGO_AUTH_METHODS.
+const (
+	AuthBearer = "bearer"
+	AuthQuery  = "query"
+	AuthCookie = "cookie"
+	AuthHMAC   = "hmac"
+)
+
+var errNoCredentials = errors.New("missing credentials")
+
+// Authenticator authenticates requests carrying one kind of credentials, and
+// returns errNoCredentials for requests without them.
+type Authenticator interface {
+	Authenticate(r *http.Request) (auth.Auth, error)
+}
+
+// bearerAuthenticator reads the JWT of the Authorization header.
+type bearerAuthenticator struct {
+	verifier *verifier
+}
+
+func (a bearerAuthenticator) Authenticate(r *http.Request) (auth.Auth, error) {
+	t := token(r)
+	if t == "" {
+		return auth.Auth{}, errNoCredentials
+	}
+
+	return a.verifier.validate(t)
+}
+
+// queryAuthenticator reads the JWT of a query string parameter, for clients
+// such as browser EventSource which cannot set headers.
+type queryAuthenticator struct {
+	verifier *verifier
+	param    string
+}
+
+func (a queryAuthenticator) Authenticate(r *http.Request) (auth.Auth, error) {
+	t := r.URL.Query().Get(a.param)
+	if t == "" {
+		return auth.Auth{}, errNoCredentials
+	}
+
+	return a.verifier.validate(t)
+}
+
+// cookieAuthenticator reads the JWT of the session cookie issued by the auth
+// gateway.
+type cookieAuthenticator struct {
+	verifier *verifier
+	name     string
+}
+
+func (a cookieAuthenticator) Authenticate(r *http.Request) (auth.Auth, error) {
+	cookie, err := r.Cookie(a.name)
+	if err != nil || cookie.Value == "" {
+		return auth.Auth{}, errNoCredentials
+	}
+
+	return a.verifier.validate(cookie.Value)
+}
+
+// hmacAuthenticator checks the API key signature of the request.
+type hmacAuthenticator struct {
+	verifier *verifier
+}
+
+func (a hmacAuthenticator) Authenticate(r *http.Request) (auth.Auth, error) {
+	if a.verifier.apiKeys == nil || r.Header.Get("X-Auth-Apikey") == "" {
+		return auth.Auth{}, errNoCredentials
+	}
+
+	key, err := a.verifier.apiKeys.Verify(r.Header)
+	if err != nil {
+		return auth.Auth{}, err
+	}
+
+	if a.verifier.revocations.IsRevoked("", key.UID) {
+		return auth.Auth{}, errors.New("access revoked")
+	}
+
+	return key.Auth(), nil
+}
+
+// authChain tries its authenticators in order, the first finding credentials
+// in the request decides.
+type authChain struct {
+	authenticators []Authenticator
+	verifier       *verifier
+}
+
+func (c *authChain) Authenticate(r *http.Request) (auth.Auth, error) {
+	for _, a := range c.authenticators {
+		res, err := a.Authenticate(r)
+		if !errors.Is(err, errNoCredentials) {
+			return res, err
+		}
+	}
+
+	return auth.Auth{}, errNoCredentials
+}
+
+func newAuthChain(methods []string, v *verifier, config *config.Config) (*authChain, error) {
+	chain := &authChain{verifier: v}
+
+	for _, m := range methods {
+		var a Authenticator
+		switch strings.TrimSpace(m) {
+		case AuthBearer:
+			a = bearerAuthenticator{verifier: v}
+		case AuthQuery:
+			a = queryAuthenticator{verifier: v, param: config.Rango.AuthQueryParam}
+		case AuthCookie:
+			a = cookieAuthenticator{verifier: v, name: config.Rango.AuthCookie}
+		case AuthHMAC:
+			a = hmacAuthenticator{verifier: v}
+		default:
+			return nil, fmt.Errorf("unknown authentication method %q", m)
+		}
+		chain.authenticators = append(chain.authenticators, a)
+	}
+
+	if len(chain.authenticators) == 0 {
+		return nil, errors.New("no authentication method")
+	}
+
+	return chain, nil
+}
+
+// authChains builds the authenticator chain of each route from
+// RANGO_ROUTE_AUTH_METHODS, routes not listed using RANGO_AUTH_METHODS.
+type authChains struct {
+	fallback *authChain
+	routes   map[string]*authChain
+}
+
+func newAuthChains(config *config.Config, v *verifier) (*authChains, error) {
+	fallback, err := newAuthChain(config.Rango.AuthMethods, v, config)
+	if err != nil {
+		return nil, err
+	}
+
+	chains := &authChains{
+		fallback: fallback,
+		routes:   make(map[string]*authChain),
+	}
+
+	for _, entry := range config.Rango.RouteAuthMethods {
+		route, methods, ok := strings.Cut(entry, ":")
+		if !ok {
+			return nil, fmt.Errorf("invalid route authentication methods %q", entry)
+		}
+
+		chain, err := newAuthChain(strings.Split(methods, "|"), v, config)
+		if err != nil {
+			return nil, fmt.Errorf("route %s: %w", route, err)
+		}
+		chains.routes[strings.TrimSpace(route)] = chain
+	}
+
+	return chains, nil
+}
+
+func (c *authChains) route(path string) *authChain {
+	if chain, ok := c.routes[path]; ok {
+		return chain
+	}
+
+	return c.fallback
+}
@@ -0,0 +1,150 @@
+package handlers
+
+import (
+	"fmt"
+	"log"
+	"net"
+	"net/http"
+	"strings"
+)
+
+// ipFilter resolves the IP of the client behind trusted proxies, and
+// restricts it to networks.
+type ipFilter struct {
+	trusted []*net.IPNet
+	allow   []*net.IPNet
+	deny    []*net.IPNet
+}
+
+// parseNetworks parses CIDRs, bare IPs being single address networks.
+func parseNetworks(list []string) ([]*net.IPNet, error) {
+	networks := []*net.IPNet{}
+	for _, n := range list {
+		n = strings.TrimSpace(n)
+		if !strings.Contains(n, "/") {
+			ip := net.ParseIP(n)
+			if ip == nil {
+				return nil, fmt.Errorf("invalid IP %q", n)
+			}
+
+			bits := 8 * net.IPv6len
+			if ip.To4() != nil {
+				ip, bits = ip.To4(), 8*net.IPv4len
+			}
+			networks = append(networks, &net.IPNet{IP: ip, Mask: net.CIDRMask(bits, bits)})
+			continue
+		}
+
+		_, network, err := net.ParseCIDR(n)
+		if err != nil {
+			return nil, fmt.Errorf("invalid CIDR %q", n)
+		}
+		networks = append(networks, network)
+	}
+
+	return networks, nil
+}
+
+func newIPFilter(trusted, allow, deny []string) (*ipFilter, error) {
+	f := &ipFilter{}
+
+	var err error
+	if f.trusted, err = parseNetworks(trusted); err != nil {
+		return nil, err
+	}
+
+	if f.allow, err = parseNetworks(allow); err != nil {
+		return nil, err
+	}
+
+	if f.deny, err = parseNetworks(deny); err != nil {
+		return nil, err
+	}
+
+	return f, nil
+}
+
+func inNetworks(networks []*net.IPNet, ip net.IP) bool {
+	for _, n := range networks {
+		if n.Contains(ip) {
+			return true
+		}
+	}
+	return false
+}
+
+func (f *ipFilter) isTrusted(addr string) bool {
+	ip := net.ParseIP(addr)
+	return ip != nil && inNetworks(f.trusted, ip)
+}
+
+// clientIP returns the IP of the peer, or when the peer is a trusted proxy
+// the last address of X-Forwarded-For not added by a trusted proxy.
+func (f *ipFilter) clientIP(r *http.Request) string {
+	ip, _, err := net.SplitHostPort(r.RemoteAddr)
+	if err != nil {
+		ip = r.RemoteAddr
+	}
+
+	if !f.isTrusted(ip) {
+		return ip
+	}
+
+	forwarded := []string{}
+	for _, h := range r.Header.Values("X-Forwarded-For") {
+		forwarded = append(forwarded, strings.Split(h, ",")...)
+	}
+
+	for i := len(forwarded) - 1; i >= 0; i-- {
+		addr := strings.TrimSpace(forwarded[i])
+		if net.ParseIP(addr) == nil {
+			break
+		}
+
+		ip = addr
+		if !f.isTrusted(addr) {
+			break
+		}
+	}
+
+	return ip
+}
+
+// permitted tells whether the IP is in no denied network, and in one of the
+// allowed ones when there are.
+func (f *ipFilter) permitted(addr string) bool {
+	ip := net.ParseIP(addr)
+	if ip == nil {
+		return len(f.allow) == 0 && len(f.deny) == 0
+	}
+
+	if inNetworks(f.deny, ip) {
+		return false
+	}
+
+	return len(f.allow) == 0 || inNetworks(f.allow, ip)
+}
+
+// ipHandler rejects clients not permitted by the filter and passes the client
+// IP to h through the ClientIP header, cleared first so a client cannot supply
+// it itself.
+func ipHandler(h http.HandlerFunc, f *ipFilter) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		r.Header.Del("ClientIP")
+
+		ip := f.clientIP(r)
+		if !f.permitted(ip) {
+			log.Printf("Refused %s from %s\n", r.URL.Path, ip)
+			w.WriteHeader(http.StatusForbidden)
+			return
+		}
+
+		r.Header.Set("ClientIP", ip)
+		h(w, r)
+	}
+}
+
+// requestIP reads the client IP set by ipHandler.
+func requestIP(r *http.Request) string {
+	return r.Header.Get("ClientIP")
+}
@@ -0,0 +1,133 @@
+package handlers
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"testing"
+)
+
+func TestClientIP(t *testing.T) {
+	f, err := newIPFilter([]string{"10.0.0.0/8", "192.168.1.1"}, nil, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	tests := []struct {
+		name       string
+		remoteAddr string
+		forwarded  []string
+		ip         string
+	}{
+		{"direct", "203.0.113.7:1234", nil, "203.0.113.7"},
+		{"untrusted peer", "203.0.113.7:1234", []string{"198.51.100.1"}, "203.0.113.7"},
+		{"trusted proxy", "10.0.0.2:1234", []string{"198.51.100.1"}, "198.51.100.1"},
+		{"trusted proxy without header", "10.0.0.2:1234", nil, "10.0.0.2"},
+		{"single trusted address", "192.168.1.1:1234", []string{"198.51.100.1"}, "198.51.100.1"},
+		{"multi-hop", "10.0.0.2:1234", []string{"198.51.100.1, 10.0.0.3, 10.0.0.4"}, "198.51.100.1"},
+		{"multi-hop headers", "10.0.0.2:1234", []string{"198.51.100.1", "10.0.0.3"}, "198.51.100.1"},
+		{"spoofed first hop", "10.0.0.2:1234", []string{"1.1.1.1, 198.51.100.1"}, "198.51.100.1"},
+		{"spoofed through untrusted hop", "10.0.0.2:1234", []string{"1.1.1.1, 198.51.100.1, 10.0.0.3"}, "198.51.100.1"},
+		{"invalid entry", "10.0.0.2:1234", []string{"198.51.100.1, garbage"}, "10.0.0.2"},
+		{"only proxies", "10.0.0.2:1234", []string{"10.0.0.3, 10.0.0.4"}, "10.0.0.3"},
+		{"ipv6", "[2001:db8::1]:1234", []string{"198.51.100.1"}, "2001:db8::1"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			r := httptest.NewRequest(http.MethodGet, "/", nil)
+			r.RemoteAddr = tt.remoteAddr
+			for _, h := range tt.forwarded {
+				r.Header.Add("X-Forwarded-For", h)
+			}
+
+			if ip := f.clientIP(r); ip != tt.ip {
+				t.Errorf("clientIP = %s, want %s", ip, tt.ip)
+			}
+		})
+	}
+}
+
+func TestIPFilterPermitted(t *testing.T) {
+	tests := []struct {
+		name  string
+		allow []string
+		deny  []string
+		ip    string
+		ok    bool
+	}{
+		{"no rules", nil, nil, "203.0.113.7", true},
+		{"allowed network", []string{"10.0.0.0/8"}, nil, "10.1.2.3", true},
+		{"outside allowed networks", []string{"10.0.0.0/8"}, nil, "11.0.0.1", false},
+		{"allowed address", []string{"203.0.113.7"}, nil, "203.0.113.7", true},
+		{"next to allowed address", []string{"203.0.113.7"}, nil, "203.0.113.8", false},
+		{"denied network", nil, []string{"203.0.113.0/24"}, "203.0.113.7", false},
+		{"outside denied network", nil, []string{"203.0.113.0/24"}, "203.0.114.1", true},
+		{"deny wins over allow", []string{"10.0.0.0/8"}, []string{"10.0.0.0/16"}, "10.0.1.1", false},
+		{"allowed outside denied", []string{"10.0.0.0/8"}, []string{"10.0.0.0/16"}, "10.1.0.1", true},
+		{"ipv6 network", []string{"2001:db8::/32"}, nil, "2001:db8::1", true},
+		{"ipv4 against ipv6 network", []string{"2001:db8::/32"}, nil, "10.0.0.1", false},
+		{"mapped ipv4", []string{"10.0.0.0/8"}, nil, "::ffff:10.0.0.1", true},
+		{"invalid address with rules", []string{"10.0.0.0/8"}, nil, "garbage", false},
+		{"invalid address without rules", nil, nil, "garbage", true},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			f, err := newIPFilter(nil, tt.allow, tt.deny)
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			if ok := f.permitted(tt.ip); ok != tt.ok {
+				t.Errorf("permitted(%s) = %v, want %v", tt.ip, ok, tt.ok)
+			}
+		})
+	}
+}
+
+func TestParseNetworksInvalid(t *testing.T) {
+	for _, n := range []string{"10.0.0.0/33", "10.0.0", "example.com", ""} {
+		if _, err := parseNetworks([]string{n}); err == nil {
+			t.Errorf("%q parsed", n)
+		}
+	}
+}
+
+func TestIPHandler(t *testing.T) {
+	f, err := newIPFilter([]string{"10.0.0.0/8"}, nil, []string{"198.51.100.0/24"})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	serve := func(remoteAddr, forwarded string) (int, string) {
+		var ip string
+		h := ipHandler(func(w http.ResponseWriter, r *http.Request) {
+			ip = requestIP(r)
+		}, f)
+
+		r := httptest.NewRequest(http.MethodGet, "/", nil)
+		r.RemoteAddr = remoteAddr
+		r.Header.Set("ClientIP", "10.9.9.9")
+		if forwarded != "" {
+			r.Header.Set("X-Forwarded-For", forwarded)
+		}
+
+		w := httptest.NewRecorder()
+		h(w, r)
+		return w.Code, ip
+	}
+
+	if code, ip := serve("203.0.113.7:1234", ""); code != http.StatusOK || ip != "203.0.113.7" {
+		t.Errorf("direct client got %d with ip %q", code, ip)
+	}
+
+	// The denied client is found behind the trusted proxy
+	if code, _ := serve("10.0.0.2:1234", "198.51.100.1"); code != http.StatusForbidden {
+		t.Errorf("denied client behind a trusted proxy got %d", code)
+	}
+
+	// but cannot hide behind a forged header
+	if code, ip := serve("198.51.100.1:1234", "203.0.113.7"); code != http.StatusForbidden || ip != "" {
+		t.Errorf("denied client forging X-Forwarded-For got %d with ip %q", code, ip)
+	}
+}
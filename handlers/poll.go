@@ -0,0 +1,241 @@
+package handlers
+
+import (
+	"crypto/rand"
+	"encoding/hex"
+	"encoding/json"
+	"log"
+	"net/http"
+	"sync"
+	"time"
+
+	"github.com/shinhagunn/websocket/pkg/routing"
+)
+
+const (
+	// Time a poll request waits for events before returning empty.
+	pollWait = 30 * time.Second
+
+	// Sessions not polled for this long are closed.
+	pollSessionTTL = 2 * time.Minute
+
+	// Maximum events buffered for a session between two polls.
+	pollQueueSize = 512
+
+	// Maximum events returned by a single poll.
+	maxPollBatch = 100
+)
+
+type pollSession struct {
+	client   *routing.Client
+	conn     *routing.QueueConn
+	lastPoll time.Time
+}
+
+// pollSessions keeps the long-polling clients, keyed by session id.
+type pollSessions struct {
+	epoll    *routing.Epoll
+	sessions map[string]*pollSession
+	mutex    sync.Mutex
+}
+
+type pollRequest struct {
+	Streams []string `json:"streams"`
+}
+
+func newPollSessions(epoll *routing.Epoll) *pollSessions {
+	return &pollSessions{
+		epoll:    epoll,
+		sessions: make(map[string]*pollSession),
+	}
+}
+
+func newSessionID() (string, error) {
+	b := make([]byte, 16)
+	if _, err := rand.Read(b); err != nil {
+		return "", err
+	}
+
+	return hex.EncodeToString(b), nil
+}
+
+func writeJSON(w http.ResponseWriter, status int, v interface{}) {
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(status)
+	json.NewEncoder(w).Encode(v)
+}
+
+func (p *pollSessions) get(r *http.Request) (*pollSession, bool) {
+	p.mutex.Lock()
+	defer p.mutex.Unlock()
+
+	s, ok := p.sessions[r.URL.Query().Get("session")]
+	return s, ok
+}
+
+func (p *pollSessions) remove(id string) {
+	p.mutex.Lock()
+	s, ok := p.sessions[id]
+	delete(p.sessions, id)
+	p.mutex.Unlock()
+
+	if !ok {
+		return
+	}
+
+	if err := p.epoll.Remove(s.client); err != nil {
+		log.Printf("Failed to remove %v\n", err)
+	}
+}
+
+// reap closes sessions that stopped polling or whose queue was dropped.
+func (p *pollSessions) reap() {
+	for range time.Tick(pollSessionTTL / 4) {
+		expired := []string{}
+
+		p.mutex.Lock()
+		for id, s := range p.sessions {
+			select {
+			case <-s.conn.Done():
+				expired = append(expired, id)
+				continue
+			default:
+			}
+
+			if time.Since(s.lastPoll) > pollSessionTTL {
+				expired = append(expired, id)
+			}
+		}
+		p.mutex.Unlock()
+
+		for _, id := range expired {
+			p.remove(id)
+		}
+	}
+}
+
+func (p *pollSessions) connectHandler() http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		if r.Method != http.MethodPost {
+			w.WriteHeader(http.StatusMethodNotAllowed)
+			return
+		}
+
+		id, err := newSessionID()
+		if err != nil {
+			w.WriteHeader(http.StatusInternalServerError)
+			return
+		}
+
+		auth := requestAuth(r)
+
+		conn := routing.NewQueueConn(pollQueueSize)
+		client := routing.NewClient(conn, auth)
+		client.IP = requestIP(r)
+
+		if err := p.epoll.Attach(client); err != nil {
+			http.Error(w, err.Error(), http.StatusTooManyRequests)
+			return
+		}
+
+		session := &pollSession{
+			client:   client,
+			conn:     conn,
+			lastPoll: time.Now(),
+		}
+
+		p.mutex.Lock()
+		p.sessions[id] = session
+		p.mutex.Unlock()
+
+		writeJSON(w, http.StatusOK, map[string]string{"session": id})
+	}
+}
+
+// subscriptionHandler handles subscribe and unsubscribe requests, their
+// responses are delivered through the next poll like any other message.
+func (p *pollSessions) subscriptionHandler(subscribe bool) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		if r.Method != http.MethodPost {
+			w.WriteHeader(http.StatusMethodNotAllowed)
+			return
+		}
+
+		session, ok := p.get(r)
+		if !ok {
+			w.WriteHeader(http.StatusNotFound)
+			return
+		}
+
+		var req pollRequest
+		if err := json.NewDecoder(r.Body).Decode(&req); err != nil || len(req.Streams) == 0 {
+			writeJSON(w, http.StatusBadRequest, map[string]string{"error": "no streams provided"})
+			return
+		}
+
+		var handled bool
+		if subscribe {
+			handled = p.epoll.Subscribe(session.client, req.Streams, nil)
+		} else {
+			handled = p.epoll.Unsubscribe(session.client, req.Streams)
+		}
+
+		if !handled {
+			w.WriteHeader(http.StatusTooManyRequests)
+			return
+		}
+
+		w.WriteHeader(http.StatusAccepted)
+	}
+}
+
+// pollHandler blocks until messages are queued for the session or pollWait
+// elapses, then returns every queued message as a JSON array.
+func (p *pollSessions) pollHandler() http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		if r.Method != http.MethodGet {
+			w.WriteHeader(http.StatusMethodNotAllowed)
+			return
+		}
+
+		session, ok := p.get(r)
+		if !ok {
+			w.WriteHeader(http.StatusNotFound)
+			return
+		}
+
+		p.mutex.Lock()
+		session.lastPoll = time.Now()
+		p.mutex.Unlock()
+
+		messages := []json.RawMessage{}
+		timer := time.NewTimer(pollWait)
+		defer timer.Stop()
+
+		select {
+		case <-r.Context().Done():
+			return
+		case <-session.conn.Done():
+			w.WriteHeader(http.StatusGone)
+			return
+		case <-timer.C:
+		case m := <-session.conn.Messages():
+			messages = append(messages, m.Data)
+		drain:
+			for len(messages) < maxPollBatch {
+				select {
+				case m := <-session.conn.Messages():
+					messages = append(messages, m.Data)
+				default:
+					break drain
+				}
+			}
+		}
+
+		p.mutex.Lock()
+		session.lastPoll = time.Now()
+		p.mutex.Unlock()
+
+		writeJSON(w, http.StatusOK, messages)
+	}
+}
@@ -5,8 +5,10 @@ import (
 	"net/http"
 	"time"
 
+	"github.com/cockroachdb/errors"
 	"github.com/gorilla/websocket"
 	"github.com/shinhagunn/websocket/config"
+	"github.com/shinhagunn/websocket/pkg/auth"
 	"github.com/shinhagunn/websocket/pkg/routing"
 )
 
@@ -24,17 +26,36 @@ const (
 	maxMessageSize = 512
 )
 
-var upgrader = websocket.Upgrader{
-	ReadBufferSize:  1024,
-	WriteBufferSize: 1024,
-	// CheckOrigin:     checkSameOrigin(os.Getenv("API_CORS_ORIGINS")),
-	CheckOrigin: func(r *http.Request) bool {
-		return true
-	},
+// newUpgrader returns an upgrader accepting the origins, or those of
+// API_CORS_ORIGINS when empty.
+func newUpgrader(config *config.Config, origins []string) (*websocket.Upgrader, error) {
+	if len(origins) == 0 {
+		origins = config.CORSOrigins
+	}
+
+	checkOrigin, err := checkSameOrigin(origins)
+	if err != nil {
+		return nil, err
+	}
+
+	return &websocket.Upgrader{
+		ReadBufferSize:    1024,
+		WriteBufferSize:   1024,
+		CheckOrigin:       checkOrigin,
+		EnableCompression: config.Rango.EnableCompression,
+	}, nil
 }
 
-func wsHandler(epoll *routing.Epoll) http.HandlerFunc {
+func wsHandler(epoll *routing.Epoll, upgrader *websocket.Upgrader) http.HandlerFunc {
 	return func(w http.ResponseWriter, r *http.Request) {
+		auth := requestAuth(r)
+		ip := requestIP(r)
+
+		if err := epoll.CheckLimits(auth, ip); err != nil {
+			http.Error(w, err.Error(), http.StatusTooManyRequests)
+			return
+		}
+
 		conn, err := upgrader.Upgrade(w, r, nil)
 		if err != nil {
 			return
@@ -47,31 +68,122 @@ func wsHandler(epoll *routing.Epoll) http.HandlerFunc {
 		// 	return nil
 		// })
 
-		auth := routing.Auth{
-			UID:  r.Header.Get("JwtUID"),
-			Role: r.Header.Get("JwtRole"),
-		}
-
 		client := routing.NewClient(conn, auth)
+		client.IP = ip
 
 		if err := epoll.Add(client); err != nil {
 			log.Printf("Failed to add connection %v", err)
+			// Raced with another connection past CheckLimits
+			if errors.Is(err, routing.ErrConnectionLimit) {
+				conn.WriteControl(websocket.CloseMessage, websocket.FormatCloseMessage(websocket.CloseTryAgainLater, err.Error()), time.Now().Add(writeWait))
+			}
 			conn.Close()
 		}
 	}
 }
 
 func SetupRoutes(config *config.Config, epoll *routing.Epoll) error {
-	// pub, err := getPublicKey(config)
-	// if err != nil {
-	// 	return errors.Wrap(err, "Loading public key failed")
-	// }
+	keys, err := getKeySet(config)
+	if err != nil {
+		return errors.Wrap(err, "Loading public key failed")
+	}
+
+	if config.JWTJWKSURL != "" || config.JWTJWKSFile != "" {
+		go keys.RefreshEvery(config.JWTJWKSRefresh)
+	}
+
+	publicUpgrader, err := newUpgrader(config, config.Rango.PublicOrigins)
+	if err != nil {
+		return errors.Wrap(err, "Invalid public origins")
+	}
+
+	privateUpgrader, err := newUpgrader(config, config.Rango.PrivateOrigins)
+	if err != nil {
+		return errors.Wrap(err, "Invalid private origins")
+	}
+
+	revocations := auth.NewRevocationList(config.Rango.RevocationTTL)
+	epoll.Revocations = revocations
+	if config.Rango.RevocationKafkaTopic != "" {
+		go epoll.ConsumeRevocations()
+	}
+
+	verifier, err := newVerifier(config, keys, revocations)
+	if err != nil {
+		return errors.Wrap(err, "Loading API keys failed")
+	}
+	epoll.ValidateToken = tokenValidator(verifier)
+
+	chains, err := newAuthChains(config, verifier)
+	if err != nil {
+		return errors.Wrap(err, "Invalid authentication methods")
+	}
 
-	http.HandleFunc("/", authHandler(wsHandler(epoll), nil, false))
-	http.HandleFunc("/public", authHandler(wsHandler(epoll), nil, false))
-	http.HandleFunc("/private", authHandler(wsHandler(epoll), nil, true))
+	publicIPs, err := newIPFilter(config.Rango.TrustedProxies, config.Rango.PublicAllowIPs, config.Rango.PublicDenyIPs)
+	if err != nil {
+		return errors.Wrap(err, "Invalid public IP lists")
+	}
+
+	privateIPs, err := newIPFilter(config.Rango.TrustedProxies, config.Rango.PrivateAllowIPs, config.Rango.PrivateDenyIPs)
+	if err != nil {
+		return errors.Wrap(err, "Invalid private IP lists")
+	}
+
+	adminIPs, err := newIPFilter(config.Rango.TrustedProxies, config.Rango.AdminAllowIPs, config.Rango.AdminDenyIPs)
+	if err != nil {
+		return errors.Wrap(err, "Invalid admin IP lists")
+	}
+
+	http.HandleFunc("/", ipHandler(authHandler(wsHandler(epoll, publicUpgrader), chains.route("/"), false), publicIPs))
+	http.HandleFunc("/public", ipHandler(authHandler(wsHandler(epoll, publicUpgrader), chains.route("/public"), false), publicIPs))
+	http.HandleFunc("/private", ipHandler(authHandler(wsHandler(epoll, privateUpgrader), chains.route("/private"), true), privateIPs))
+	http.HandleFunc("/sse", ipHandler(authHandler(sseHandler(epoll), chains.route("/sse"), false), publicIPs))
+
+	polls := newPollSessions(epoll)
+	go polls.reap()
+
+	http.HandleFunc("/poll/connect", ipHandler(authHandler(polls.connectHandler(), chains.route("/poll/connect"), false), publicIPs))
+	http.HandleFunc("/poll/subscribe", ipHandler(polls.subscriptionHandler(true), publicIPs))
+	http.HandleFunc("/poll/unsubscribe", ipHandler(polls.subscriptionHandler(false), publicIPs))
+	http.HandleFunc("/poll", ipHandler(polls.pollHandler(), publicIPs))
+
+	http.HandleFunc("/admin/revoke", ipHandler(auditHandler(authHandler(adminOnly(revokeHandler(epoll), config), chains.route("/admin/revoke"), true), epoll.Audit), adminIPs))
+	http.HandleFunc("/admin/connections", ipHandler(auditHandler(authHandler(adminOnly(connectionsHandler(epoll), config), chains.route("/admin/connections"), true), epoll.Audit), adminIPs))
+	http.HandleFunc("/admin/stats", ipHandler(auditHandler(authHandler(adminOnly(statsHandler(epoll), config), chains.route("/admin/stats"), true), epoll.Audit), adminIPs))
+
+	if config.Rango.TLSCertFile == "" {
+		if config.Rango.MTLSClientCA != "" {
+			return errors.New("RANGO_MTLS_CLIENT_CA requires RANGO_TLS_CERT_FILE")
+		}
+
+		if err := http.ListenAndServe("0.0.0.0:8080", nil); err != nil {
+			return err
+		}
+
+		return nil
+	}
+
+	certs, err := newCertReloader(config.Rango.TLSCertFile, config.Rango.TLSKeyFile)
+	if err != nil {
+		return errors.Wrap(err, "Loading certificate failed")
+	}
+	go certs.Watch(config.Rango.TLSReload)
+
+	tlsConfig, err := newTLSConfig(config, certs)
+	if err != nil {
+		return errors.Wrap(err, "Loading client CA failed")
+	}
+
+	if config.Rango.MTLSClientCA != "" {
+		http.HandleFunc("/mtls", ipHandler(certHandler(wsHandler(epoll, privateUpgrader), config, revocations), privateIPs))
+	}
+
+	server := &http.Server{
+		Addr:      "0.0.0.0:8080",
+		TLSConfig: tlsConfig,
+	}
 
-	if err := http.ListenAndServe("0.0.0.0:8080", nil); err != nil {
+	if err := server.ListenAndServeTLS("", ""); err != nil {
 		return err
 	}
 
@@ -0,0 +1,155 @@
+package handlers
+
+import (
+	"bytes"
+	"fmt"
+	"log"
+	"net/http"
+	"net/url"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/shinhagunn/websocket/pkg/routing"
+)
+
+// Maximum events buffered for a server-sent events stream before it is dropped.
+const sseQueueSize = 256
+
+// streamsParam reads the streams from ?streams=a,b and repeated ?stream= values.
+func streamsParam(query url.Values) []string {
+	streams := []string{}
+
+	for _, v := range append(query["streams"], query["stream"]...) {
+		for _, s := range strings.Split(v, ",") {
+			s = strings.TrimSpace(s)
+			if s != "" {
+				streams = append(streams, s)
+			}
+		}
+	}
+
+	return streams
+}
+
+// parseEventID decodes an event id of the form "stream=seq&stream=seq" into
+// the last sequence seen per stream.
+func parseEventID(id string) map[string]uint64 {
+	lastSeq := map[string]uint64{}
+	if id == "" {
+		return lastSeq
+	}
+
+	values, err := url.ParseQuery(id)
+	if err != nil {
+		return lastSeq
+	}
+
+	for stream := range values {
+		seq, err := strconv.ParseUint(values.Get(stream), 10, 64)
+		if err == nil {
+			lastSeq[stream] = seq
+		}
+	}
+
+	return lastSeq
+}
+
+func formatEventID(lastSeq map[string]uint64) string {
+	values := url.Values{}
+	for stream, seq := range lastSeq {
+		values.Set(stream, strconv.FormatUint(seq, 10))
+	}
+
+	return values.Encode()
+}
+
+// writeSSE writes one event, splitting multi-line data as the format requires.
+func writeSSE(w http.ResponseWriter, id string, data []byte) {
+	if id != "" {
+		fmt.Fprintf(w, "id: %s\n", id)
+	}
+
+	for _, line := range bytes.Split(data, []byte{'\n'}) {
+		fmt.Fprintf(w, "data: %s\n", line)
+	}
+	fmt.Fprint(w, "\n")
+}
+
+func sseHandler(epoll *routing.Epoll) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		flusher, ok := w.(http.Flusher)
+		if !ok {
+			http.Error(w, "streaming unsupported", http.StatusInternalServerError)
+			return
+		}
+
+		streams := streamsParam(r.URL.Query())
+		if len(streams) == 0 {
+			http.Error(w, "no streams provided", http.StatusBadRequest)
+			return
+		}
+
+		// EventSource polyfills that cannot set headers pass it in the query.
+		lastEventID := r.Header.Get("Last-Event-ID")
+		if lastEventID == "" {
+			lastEventID = r.URL.Query().Get("lastEventId")
+		}
+		lastSeq := parseEventID(lastEventID)
+
+		auth := requestAuth(r)
+
+		conn := routing.NewQueueConn(sseQueueSize)
+		client := routing.NewClient(conn, auth)
+		client.IP = requestIP(r)
+
+		if err := epoll.Attach(client); err != nil {
+			http.Error(w, err.Error(), http.StatusTooManyRequests)
+			return
+		}
+		defer func() {
+			if err := epoll.Remove(client); err != nil {
+				log.Printf("Failed to remove %v\n", err)
+			}
+		}()
+
+		// Events replayed by the subscription wait in the queue until the
+		// stream is open
+		if !epoll.Subscribe(client, streams, lastSeq) {
+			http.Error(w, "subscription refused", http.StatusTooManyRequests)
+			return
+		}
+
+		header := w.Header()
+		header.Set("Content-Type", "text/event-stream")
+		header.Set("Cache-Control", "no-cache")
+		header.Set("Connection", "keep-alive")
+		header.Set("X-Accel-Buffering", "no")
+		w.WriteHeader(http.StatusOK)
+		flusher.Flush()
+
+		ticker := time.NewTicker(pingPeriod)
+		defer ticker.Stop()
+
+		for {
+			select {
+			case <-r.Context().Done():
+				return
+			case <-conn.Done():
+				return
+			case <-ticker.C:
+				fmt.Fprint(w, ": ping\n\n")
+				flusher.Flush()
+			case m := <-conn.Messages():
+				id := ""
+				if m.Stream != "" {
+					lastSeq[m.Stream] = m.Seq
+					id = formatEventID(lastSeq)
+				}
+
+				writeSSE(w, id, m.Data)
+				flusher.Flush()
+			}
+		}
+	}
+}
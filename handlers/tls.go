@@ -0,0 +1,206 @@
+package handlers
+
+import (
+	"crypto/tls"
+	"crypto/x509"
+	"errors"
+	"fmt"
+	"log"
+	"net/http"
+	"os"
+	"sync/atomic"
+	"time"
+
+	"github.com/shinhagunn/websocket/config"
+	"github.com/shinhagunn/websocket/pkg/auth"
+	"github.com/shinhagunn/websocket/pkg/routing"
+)
+
+// Certificate fields a client certificate identity can be read from, see
+// RANGO_MTLS_IDENTITY.
+const (
+	IdentityCN    = "cn"
+	IdentityEmail = "email"
+	IdentityURI   = "uri"
+	IdentityDNS   = "dns"
+)
+
+// certReloader serves the certificate of its files, reloaded when they change.
+type certReloader struct {
+	certFile string
+	keyFile  string
+
+	cert    atomic.Pointer[tls.Certificate]
+	modTime time.Time
+}
+
+func newCertReloader(certFile, keyFile string) (*certReloader, error) {
+	c := &certReloader{
+		certFile: certFile,
+		keyFile:  keyFile,
+	}
+
+	modTime, err := c.lastModified()
+	if err != nil {
+		return nil, err
+	}
+
+	if err := c.load(); err != nil {
+		return nil, err
+	}
+	c.modTime = modTime
+
+	return c, nil
+}
+
+func (c *certReloader) lastModified() (time.Time, error) {
+	last := time.Time{}
+	for _, file := range []string{c.certFile, c.keyFile} {
+		info, err := os.Stat(file)
+		if err != nil {
+			return last, err
+		}
+
+		if info.ModTime().After(last) {
+			last = info.ModTime()
+		}
+	}
+
+	return last, nil
+}
+
+func (c *certReloader) load() error {
+	cert, err := tls.LoadX509KeyPair(c.certFile, c.keyFile)
+	if err != nil {
+		return err
+	}
+
+	c.cert.Store(&cert)
+	return nil
+}
+
+func (c *certReloader) GetCertificate(*tls.ClientHelloInfo) (*tls.Certificate, error) {
+	return c.cert.Load(), nil
+}
+
+// Watch reloads the certificate when its files change, checking them every
+// period. A certificate failing to load is kept until its next change.
+func (c *certReloader) Watch(period time.Duration) {
+	for range time.Tick(period) {
+		modTime, err := c.lastModified()
+		if err != nil || modTime.Equal(c.modTime) {
+			continue
+		}
+
+		// Both files are written separately, the pair may not match yet
+		if err := c.load(); err != nil {
+			log.Printf("Failed to reload certificate: %v\n", err)
+			continue
+		}
+
+		c.modTime = modTime
+		log.Printf("Reloaded certificate from %s\n", c.certFile)
+	}
+}
+
+// newTLSConfig serves the certificate of the reloader, and verifies client
+// certificates against the RANGO_MTLS_CLIENT_CA bundle when set.
+func newTLSConfig(config *config.Config, certs *certReloader) (*tls.Config, error) {
+	conf := &tls.Config{
+		MinVersion:     tls.VersionTLS12,
+		GetCertificate: certs.GetCertificate,
+	}
+
+	if config.Rango.MTLSClientCA == "" {
+		return conf, nil
+	}
+
+	switch config.Rango.MTLSIdentity {
+	case IdentityCN, IdentityEmail, IdentityURI, IdentityDNS:
+	default:
+		return nil, fmt.Errorf("unknown certificate identity %q", config.Rango.MTLSIdentity)
+	}
+
+	data, err := os.ReadFile(config.Rango.MTLSClientCA)
+	if err != nil {
+		return nil, err
+	}
+
+	pool := x509.NewCertPool()
+	if !pool.AppendCertsFromPEM(data) {
+		return nil, fmt.Errorf("no certificate in %s", config.Rango.MTLSClientCA)
+	}
+
+	// Only the mTLS endpoint requires a client certificate
+	conf.ClientAuth = tls.VerifyClientCertIfGiven
+	conf.ClientCAs = pool
+
+	return conf, nil
+}
+
+// certAuth maps a verified client certificate to an identity. The uid is read
+// from the RANGO_MTLS_IDENTITY field, the role from the first organizational
+// unit of the subject, RANGO_MTLS_ROLE when it has none.
+func certAuth(cert *x509.Certificate, config *config.Config) (routing.Auth, error) {
+	id := routing.Auth{
+		Role:      config.Rango.MTLSRole,
+		Level:     config.Rango.MTLSLevel,
+		ExpiresAt: cert.NotAfter,
+	}
+
+	switch config.Rango.MTLSIdentity {
+	case IdentityCN:
+		id.UID = cert.Subject.CommonName
+	case IdentityEmail:
+		if len(cert.EmailAddresses) > 0 {
+			id.UID = cert.EmailAddresses[0]
+		}
+	case IdentityURI:
+		if len(cert.URIs) > 0 {
+			id.UID = cert.URIs[0].String()
+		}
+	case IdentityDNS:
+		if len(cert.DNSNames) > 0 {
+			id.UID = cert.DNSNames[0]
+		}
+	default:
+		return id, fmt.Errorf("unknown certificate identity %q", config.Rango.MTLSIdentity)
+	}
+
+	if id.UID == "" {
+		return id, errors.New("certificate has no " + config.Rango.MTLSIdentity)
+	}
+
+	if len(cert.Subject.OrganizationalUnit) > 0 {
+		id.Role = cert.Subject.OrganizationalUnit[0]
+	}
+
+	return id, nil
+}
+
+// certHandler requires a verified client certificate of a user that is not
+// revoked and passes its identity to h like authHandler.
+func certHandler(h http.HandlerFunc, config *config.Config, revocations *auth.RevocationList) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		clearRequestAuth(r)
+
+		if r.TLS == nil || len(r.TLS.VerifiedChains) == 0 {
+			http.Error(w, "client certificate required", http.StatusUnauthorized)
+			return
+		}
+
+		id, err := certAuth(r.TLS.VerifiedChains[0][0], config)
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusUnauthorized)
+			return
+		}
+
+		if revocations.IsRevoked("", id.UID) {
+			http.Error(w, "access revoked", http.StatusUnauthorized)
+			return
+		}
+
+		setRequestAuth(r, id)
+		h(w, r)
+	}
+}
@@ -0,0 +1,76 @@
+package handlers
+
+import (
+	"crypto/tls"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/shinhagunn/websocket/config"
+	"github.com/shinhagunn/websocket/pkg/auth"
+)
+
+// serveCert runs a request presenting a verified certificate of the subject
+// through certHandler and returns the response with the uid the wrapped
+// handler saw.
+func serveCert(revocations *auth.RevocationList, subject string) (*httptest.ResponseRecorder, string) {
+	conf := &config.Config{}
+	conf.Rango.MTLSIdentity = IdentityCN
+
+	var uid string
+	h := certHandler(func(w http.ResponseWriter, r *http.Request) {
+		uid = requestAuth(r).UID
+	}, conf, revocations)
+
+	r := httptest.NewRequest(http.MethodGet, "/mtls", nil)
+	r.TLS = &tls.ConnectionState{
+		VerifiedChains: [][]*x509.Certificate{{{
+			Subject:  pkix.Name{CommonName: subject},
+			NotAfter: time.Now().Add(time.Hour),
+		}}},
+	}
+
+	w := httptest.NewRecorder()
+	h(w, r)
+
+	return w, uid
+}
+
+func TestCertHandlerAdmitsSubject(t *testing.T) {
+	w, uid := serveCert(auth.NewRevocationList(time.Hour), "UID1")
+	if w.Code != http.StatusOK || uid != "UID1" {
+		t.Errorf("certificate refused with %d, uid %q", w.Code, uid)
+	}
+}
+
+func TestCertHandlerRejectsRevokedSubject(t *testing.T) {
+	revocations := auth.NewRevocationList(time.Hour)
+	if err := revocations.Revoke(auth.Revocation{UID: "UID1"}); err != nil {
+		t.Fatal(err)
+	}
+
+	w, uid := serveCert(revocations, "UID1")
+	if w.Code != http.StatusUnauthorized || uid != "" {
+		t.Errorf("revoked certificate answered %d, uid %q", w.Code, uid)
+	}
+
+	if w, _ := serveCert(revocations, "UID2"); w.Code != http.StatusOK {
+		t.Errorf("certificate of another user refused with %d", w.Code)
+	}
+}
+
+func TestCertHandlerRequiresCertificate(t *testing.T) {
+	h := certHandler(func(w http.ResponseWriter, r *http.Request) {
+		t.Error("request without certificate admitted")
+	}, &config.Config{}, nil)
+
+	w := httptest.NewRecorder()
+	h(w, httptest.NewRequest(http.MethodGet, "/mtls", nil))
+
+	if w.Code != http.StatusUnauthorized {
+		t.Errorf("request without certificate answered %d", w.Code)
+	}
+}
@@ -1,14 +1,18 @@
 package handlers
 
 import (
-	"crypto/rsa"
+	"crypto"
+	"errors"
 	"fmt"
 	"net/http"
 	"net/url"
+	"strconv"
 	"strings"
+	"time"
 
 	"github.com/shinhagunn/websocket/config"
 	"github.com/shinhagunn/websocket/pkg/auth"
+	"github.com/shinhagunn/websocket/pkg/routing"
 )
 
 const prefix = "Bearer "
@@ -22,31 +26,167 @@ func token(r *http.Request) string {
 	return authHeader[len(prefix):]
 }
 
-func authHandler(h http.HandlerFunc, key *rsa.PublicKey, mustAuth bool) http.HandlerFunc {
+// verifier validates tokens with the configured keys and claim policy, and
+// API key signatures when API keys are configured.
+type verifier struct {
+	keys        *auth.KeySet
+	policy      auth.ClaimPolicy
+	apiKeys     *auth.HMACVerifier
+	revocations *auth.RevocationList
+}
+
+func newVerifier(config *config.Config, keys *auth.KeySet, revocations *auth.RevocationList) (*verifier, error) {
+	v := &verifier{
+		keys:        keys,
+		revocations: revocations,
+		policy: auth.ClaimPolicy{
+			Audience:        config.JWTAudience,
+			Issuers:         config.JWTIssuers,
+			RequireActive:   config.JWTRequireActive,
+			MinPrivateLevel: config.Rango.PrivateMinLevel,
+			Leeway:          config.JWTLeeway,
+		},
+	}
+
+	if config.Rango.APIKeysFile != "" {
+		store, err := auth.NewFileAPIKeyStore(config.Rango.APIKeysFile)
+		if err != nil {
+			return nil, err
+		}
+		v.apiKeys = auth.NewHMACVerifier(store, config.Rango.HMACNonceWindow)
+	}
+
+	return v, nil
+}
+
+// validate parses the token and makes sure it identifies a user. Rejections
+// by the claim policy keep their reason, other failures are reported as an
+// invalid token.
+func (v *verifier) validate(token string) (auth.Auth, error) {
+	a, err := auth.ParseWithPolicy(token, v.keys, v.policy)
+	if err != nil {
+		var claimErr *auth.ClaimError
+		if errors.As(err, &claimErr) {
+			return a, claimErr
+		}
+		return a, errors.New("invalid token")
+	}
+
+	if a.UID == "" {
+		return a, errors.New("token has no uid")
+	}
+
+	if v.revocations.IsRevoked(a.Id, a.UID) {
+		return a, errors.New("token revoked")
+	}
+
+	return a, nil
+}
+
+// routingAuth converts the token claims to the identity of a client.
+func routingAuth(a auth.Auth) routing.Auth {
+	res := routing.Auth{
+		UID:     a.UID,
+		Role:    a.Role,
+		TokenID: a.Id,
+	}
+
+	if level, err := a.Level.Int64(); err == nil {
+		res.Level = int(level)
+	}
+
+	if a.ExpiresAt > 0 {
+		res.ExpiresAt = time.Unix(a.ExpiresAt, 0)
+	}
+
+	return res
+}
+
+// authHandler authenticates the request with the chain and passes the identity
+// to h through the JwtUID, JwtRole, JwtLevel, JwtExpiresAt and JwtID headers.
+// Those headers are always cleared first so a client cannot supply them
+// itself. When mustAuth is set the token must also grant access to private
+// streams.
+func authHandler(h http.HandlerFunc, chain *authChain, mustAuth bool) http.HandlerFunc {
 	return func(w http.ResponseWriter, r *http.Request) {
-		// auth, err := auth.ParseAndValidate(token(r), key)
-		// if err != nil && mustAuth {
-		// 	w.WriteHeader(http.StatusUnauthorized)
-		// 	return
-		// }
-
-		// if err == nil {
-		// 	r.Header.Set("JwtUID", auth.UID)
-		// 	r.Header.Set("JwtRole", auth.Role)
-		// } else {
-		// 	r.Header.Del("JwtUID")
-		// 	r.Header.Del("JwtRole")
-		// }
+		clearRequestAuth(r)
+
+		auth, err := chain.Authenticate(r)
+		if err == nil && mustAuth {
+			err = chain.verifier.policy.ValidatePrivate(auth)
+		}
+
+		if err != nil && mustAuth {
+			http.Error(w, err.Error(), http.StatusUnauthorized)
+			return
+		}
+
+		if err == nil {
+			setRequestAuth(r, routingAuth(auth))
+		}
+
 		h(w, r)
 	}
 }
 
-func getPublicKey(config *config.Config) (pub *rsa.PublicKey, err error) {
+func clearRequestAuth(r *http.Request) {
+	r.Header.Del("JwtUID")
+	r.Header.Del("JwtRole")
+	r.Header.Del("JwtLevel")
+	r.Header.Del("JwtExpiresAt")
+	r.Header.Del("JwtID")
+}
+
+func setRequestAuth(r *http.Request, id routing.Auth) {
+	exp := int64(0)
+	if !id.ExpiresAt.IsZero() {
+		exp = id.ExpiresAt.Unix()
+	}
+
+	r.Header.Set("JwtUID", id.UID)
+	r.Header.Set("JwtRole", id.Role)
+	r.Header.Set("JwtLevel", strconv.Itoa(id.Level))
+	r.Header.Set("JwtExpiresAt", strconv.FormatInt(exp, 10))
+	r.Header.Set("JwtID", id.TokenID)
+}
+
+// requestAuth reads the identity set by authHandler.
+func requestAuth(r *http.Request) routing.Auth {
+	auth := routing.Auth{
+		UID:     r.Header.Get("JwtUID"),
+		Role:    r.Header.Get("JwtRole"),
+		TokenID: r.Header.Get("JwtID"),
+	}
+
+	if level, err := strconv.Atoi(r.Header.Get("JwtLevel")); err == nil {
+		auth.Level = level
+	}
+
+	if exp, err := strconv.ParseInt(r.Header.Get("JwtExpiresAt"), 10, 64); err == nil && exp > 0 {
+		auth.ExpiresAt = time.Unix(exp, 0)
+	}
+
+	return auth
+}
+
+// tokenValidator validates the tokens sent over the socket like the upgrade
+// requests.
+func tokenValidator(v *verifier) routing.TokenValidator {
+	return func(token string) (routing.Auth, error) {
+		auth, err := v.validate(token)
+		if err != nil {
+			return routing.Auth{}, err
+		}
+
+		return routingAuth(auth), nil
+	}
+}
+
+func getPublicKey(config *config.Config) (pub crypto.PublicKey, err error) {
 	ks := auth.KeyStore{}
 	encPem := config.JWTPublicKey
-	ks.LoadPublicKeyFromString(encPem)
 
-	if err != nil {
+	if err := ks.LoadPublicKeyFromString(encPem); err != nil {
 		return nil, err
 	}
 	if ks.PublicKey == nil {
@@ -55,26 +195,85 @@ func getPublicKey(config *config.Config) (pub *rsa.PublicKey, err error) {
 	return ks.PublicKey, nil
 }
 
-func checkSameOrigin(origins string) func(r *http.Request) bool {
-	if origins == "" {
+// getKeySet loads the token verification keys: the JWKS when one is
+// configured, plus JWT_PUBLIC_KEY as the default key for tokens without kid.
+func getKeySet(config *config.Config) (*auth.KeySet, error) {
+	var keys *auth.KeySet
+	var err error
+
+	switch {
+	case config.JWTJWKSURL != "":
+		keys, err = auth.NewJWKSKeySet(config.JWTJWKSURL)
+	case config.JWTJWKSFile != "":
+		keys, err = auth.NewJWKSKeySet(config.JWTJWKSFile)
+	default:
+		keys = auth.NewKeySet()
+	}
+
+	if err != nil {
+		return nil, err
+	}
+
+	if config.JWTPublicKey != "" {
+		pub, err := getPublicKey(config)
+		if err != nil {
+			return nil, err
+		}
+		keys.Add("", pub, config.JWTAlgorithms...)
+	}
+
+	if keys.Len() == 0 {
+		return nil, fmt.Errorf("no JWT_PUBLIC_KEY, JWT_JWKS_URL or JWT_JWKS_FILE configured")
+	}
+
+	return keys, nil
+}
+
+// originHost returns the host of an allowed origin, given as an URL or a bare
+// host. A leading *. allows every subdomain of the host.
+func originHost(origin string) (string, error) {
+	if !strings.HasPrefix(origin, "http://") && !strings.HasPrefix(origin, "https://") {
+		return origin, nil
+	}
+
+	u, err := url.Parse(origin)
+	if err != nil || u.Host == "" {
+		return "", fmt.Errorf("invalid origin %q", origin)
+	}
+
+	return u.Host, nil
+}
+
+func matchOrigin(host, allowed string) bool {
+	if allowed == "*" {
+		return true
+	}
+
+	if domain, ok := strings.CutPrefix(allowed, "*."); ok {
+		return len(host) > len(domain)+1 && strings.HasSuffix(strings.ToLower(host), "."+strings.ToLower(domain))
+	}
+
+	return strings.EqualFold(host, allowed)
+}
+
+// checkSameOrigin allows requests whose Origin matches one of the origins,
+// and requests without Origin, which do not come from browsers. Every origin
+// is allowed when the list is empty.
+func checkSameOrigin(origins []string) (func(r *http.Request) bool, error) {
+	if len(origins) == 0 {
 		return func(r *http.Request) bool {
 			return true
-		}
+		}, nil
 	}
 
 	hosts := []string{}
 
-	for _, o := range strings.Split(origins, ",") {
-		o = strings.TrimSpace(o)
-		if strings.HasPrefix(o, "http://") || strings.HasPrefix(o, "https://") {
-			u, err := url.Parse(o)
-			if err != nil || u.Host == "" {
-				panic("Failed to parse url in API_CORS_ORIGINS: " + o)
-			}
-			hosts = append(hosts, u.Host)
-		} else {
-			hosts = append(hosts, o)
+	for _, o := range origins {
+		host, err := originHost(strings.TrimSpace(o))
+		if err != nil {
+			return nil, err
 		}
+		hosts = append(hosts, host)
 	}
 
 	return func(r *http.Request) bool {
@@ -88,10 +287,10 @@ func checkSameOrigin(origins string) func(r *http.Request) bool {
 		}
 
 		for _, host := range hosts {
-			if strings.EqualFold(u.Host, host) {
+			if matchOrigin(u.Host, host) {
 				return true
 			}
 		}
 		return false
-	}
+	}, nil
 }
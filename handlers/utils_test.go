@@ -0,0 +1,331 @@
+package handlers
+
+import (
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/shinhagunn/websocket/config"
+	"github.com/shinhagunn/websocket/pkg/auth"
+)
+
+// newTestChain returns a bearer chain trusting a new key, and that key to
+// forge tokens with.
+func newTestChain(t *testing.T) (*authChain, *ecdsa.PrivateKey) {
+	t.Helper()
+
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	keys := auth.NewKeySet()
+	keys.Add("", &key.PublicKey)
+
+	chain, err := newAuthChain([]string{AuthBearer}, &verifier{keys: keys}, &config.Config{})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	return chain, key
+}
+
+// serveAuth runs the request through authHandler and returns the response
+// with the identity headers the wrapped handler saw, nil if it was not called.
+func serveAuth(chain *authChain, mustAuth bool, r *http.Request) (*httptest.ResponseRecorder, http.Header) {
+	var seen http.Header
+	h := authHandler(func(w http.ResponseWriter, r *http.Request) {
+		seen = r.Header.Clone()
+	}, chain, mustAuth)
+
+	w := httptest.NewRecorder()
+	h(w, r)
+
+	return w, seen
+}
+
+var identityHeaders = []string{"JwtUID", "JwtRole", "JwtLevel", "JwtExpiresAt", "JwtID"}
+
+func TestAuthHandlerAnonymousPublic(t *testing.T) {
+	chain, _ := newTestChain(t)
+
+	w, seen := serveAuth(chain, false, httptest.NewRequest(http.MethodGet, "/public", nil))
+	if w.Code != http.StatusOK || seen == nil {
+		t.Fatalf("anonymous request refused with %d", w.Code)
+	}
+
+	for _, h := range identityHeaders {
+		if v := seen.Get(h); v != "" {
+			t.Errorf("%s = %q for an anonymous request", h, v)
+		}
+	}
+}
+
+func TestAuthHandlerInvalidTokenPrivate(t *testing.T) {
+	chain, _ := newTestChain(t)
+
+	other, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	forged, err := auth.ForgeToken("UID123", "user@example.com", "member", 3, other, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	for _, token := range []string{"not-a-token", forged} {
+		r := httptest.NewRequest(http.MethodGet, "/private", nil)
+		r.Header.Set("Authorization", "Bearer "+token)
+
+		w, seen := serveAuth(chain, true, r)
+		if w.Code != http.StatusUnauthorized || seen != nil {
+			t.Errorf("token %.20s got %d, want 401", token, w.Code)
+		}
+	}
+
+	w, seen := serveAuth(chain, true, httptest.NewRequest(http.MethodGet, "/private", nil))
+	if w.Code != http.StatusUnauthorized || seen != nil {
+		t.Errorf("request without token got %d, want 401", w.Code)
+	}
+}
+
+func TestAuthHandlerValidToken(t *testing.T) {
+	chain, key := newTestChain(t)
+
+	token, err := auth.ForgeToken("UID123", "user@example.com", "admin", 3, key, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	for _, mustAuth := range []bool{false, true} {
+		r := httptest.NewRequest(http.MethodGet, "/private", nil)
+		r.Header.Set("Authorization", "Bearer "+token)
+
+		w, seen := serveAuth(chain, mustAuth, r)
+		if w.Code != http.StatusOK || seen == nil {
+			t.Fatalf("valid token refused with %d", w.Code)
+		}
+
+		if seen.Get("JwtUID") != "UID123" || seen.Get("JwtRole") != "admin" || seen.Get("JwtLevel") != "3" {
+			t.Errorf("identity headers %v", seen)
+		}
+		if seen.Get("JwtID") == "" || seen.Get("JwtExpiresAt") == "0" {
+			t.Errorf("token id and expiry not set: %v", seen)
+		}
+	}
+}
+
+func TestAuthHandlerStripsClientHeaders(t *testing.T) {
+	chain, key := newTestChain(t)
+
+	spoof := func(r *http.Request) *http.Request {
+		r.Header.Set("JwtUID", "UIDADMIN")
+		r.Header.Set("JwtRole", "superadmin")
+		r.Header.Set("JwtLevel", "5")
+		r.Header.Set("JwtID", "spoofed")
+		return r
+	}
+
+	w, seen := serveAuth(chain, false, spoof(httptest.NewRequest(http.MethodGet, "/public", nil)))
+	if w.Code != http.StatusOK || seen == nil {
+		t.Fatalf("anonymous request refused with %d", w.Code)
+	}
+	for _, h := range identityHeaders {
+		if v := seen.Get(h); v != "" {
+			t.Errorf("client supplied %s = %q reached the handler", h, v)
+		}
+	}
+
+	w, _ = serveAuth(chain, true, spoof(httptest.NewRequest(http.MethodGet, "/private", nil)))
+	if w.Code != http.StatusUnauthorized {
+		t.Errorf("spoofed headers without token got %d, want 401", w.Code)
+	}
+
+	token, err := auth.ForgeToken("UID123", "user@example.com", "member", 1, key, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	r := spoof(httptest.NewRequest(http.MethodGet, "/private", nil))
+	r.Header.Set("Authorization", "Bearer "+token)
+
+	_, seen = serveAuth(chain, true, r)
+	if seen.Get("JwtUID") != "UID123" || seen.Get("JwtRole") != "member" || seen.Get("JwtLevel") != "1" || seen.Get("JwtID") == "spoofed" {
+		t.Errorf("client supplied headers override the token: %v", seen)
+	}
+}
+
+// apiKeys is an in memory API key store.
+type apiKeys map[string]auth.APIKey
+
+func (s apiKeys) Lookup(accessKey string) (auth.APIKey, error) {
+	key, ok := s[accessKey]
+	if !ok {
+		return auth.APIKey{}, auth.ErrUnknownAPIKey
+	}
+	return key, nil
+}
+
+// newHMACChain returns an HMAC chain accepting nonces within window and the
+// signer of its only key.
+func newHMACChain(t *testing.T, window time.Duration) (*authChain, *auth.APIKeyHMAC) {
+	t.Helper()
+
+	key := auth.APIKey{AccessKey: "access", SecretKey: "secret", UID: "UID123", Role: "member", Level: 2}
+	v := &verifier{apiKeys: auth.NewHMACVerifier(apiKeys{key.AccessKey: key}, window)}
+
+	chain, err := newAuthChain([]string{AuthHMAC}, v, &config.Config{})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	return chain, auth.NewAPIKeyHMAC(key.AccessKey, key.SecretKey)
+}
+
+// signedRequest returns a request to /private signed with the nonce.
+func signedRequest(signer *auth.APIKeyHMAC, nonce int64) *http.Request {
+	r := httptest.NewRequest(http.MethodGet, "/private", nil)
+	for h, v := range signer.GetSignedHeader(nonce) {
+		r.Header[h] = v
+	}
+	return r
+}
+
+func TestAuthHandlerHMAC(t *testing.T) {
+	chain, signer := newHMACChain(t, time.Minute)
+
+	w, seen := serveAuth(chain, true, signedRequest(signer, time.Now().UnixMilli()))
+	if w.Code != http.StatusOK || seen == nil {
+		t.Fatalf("signed request refused with %d: %s", w.Code, w.Body)
+	}
+
+	if seen.Get("JwtUID") != "UID123" || seen.Get("JwtRole") != "member" || seen.Get("JwtLevel") != "2" {
+		t.Errorf("identity headers %v", seen)
+	}
+
+	forged := auth.NewAPIKeyHMAC("access", "guessed")
+	if w, seen := serveAuth(chain, true, signedRequest(forged, time.Now().UnixMilli())); w.Code != http.StatusUnauthorized || seen != nil {
+		t.Errorf("request signed with another secret got %d", w.Code)
+	}
+}
+
+func TestAuthHandlerHMACReplayedNonce(t *testing.T) {
+	chain, signer := newHMACChain(t, time.Minute)
+	nonce := time.Now().UnixMilli()
+
+	if w, _ := serveAuth(chain, true, signedRequest(signer, nonce)); w.Code != http.StatusOK {
+		t.Fatalf("signed request refused with %d", w.Code)
+	}
+
+	w, seen := serveAuth(chain, true, signedRequest(signer, nonce))
+	if w.Code != http.StatusUnauthorized || seen != nil || !strings.Contains(w.Body.String(), auth.ErrReplayedNonce.Error()) {
+		t.Errorf("replayed nonce got %d: %s", w.Code, w.Body)
+	}
+
+	// Another nonce of the same key is still accepted
+	if w, _ := serveAuth(chain, true, signedRequest(signer, nonce+1)); w.Code != http.StatusOK {
+		t.Errorf("next nonce refused with %d", w.Code)
+	}
+}
+
+func TestAuthHandlerHMACNonceWindow(t *testing.T) {
+	chain, signer := newHMACChain(t, time.Minute)
+	now := time.Now()
+
+	for _, sent := range []time.Time{now.Add(-2 * time.Minute), now.Add(2 * time.Minute)} {
+		w, seen := serveAuth(chain, true, signedRequest(signer, sent.UnixMilli()))
+		if w.Code != http.StatusUnauthorized || seen != nil || !strings.Contains(w.Body.String(), auth.ErrInvalidNonce.Error()) {
+			t.Errorf("nonce %v from now got %d: %s", sent.Sub(now), w.Code, w.Body)
+		}
+	}
+}
+
+func TestAuthHandlerHMACNonceExpiry(t *testing.T) {
+	const window = 100 * time.Millisecond
+	chain, signer := newHMACChain(t, window)
+	nonce := time.Now().UnixMilli()
+
+	if w, _ := serveAuth(chain, true, signedRequest(signer, nonce)); w.Code != http.StatusOK {
+		t.Fatalf("signed request refused with %d", w.Code)
+	}
+
+	// Once the nonce left the cache it is outside of the window, so the
+	// replay is still refused
+	time.Sleep(2 * window)
+
+	w, _ := serveAuth(chain, true, signedRequest(signer, nonce))
+	if w.Code != http.StatusUnauthorized || !strings.Contains(w.Body.String(), auth.ErrInvalidNonce.Error()) {
+		t.Errorf("expired nonce replay got %d: %s", w.Code, w.Body)
+	}
+
+	if w, _ := serveAuth(chain, true, signedRequest(signer, time.Now().UnixMilli())); w.Code != http.StatusOK {
+		t.Errorf("fresh nonce refused with %d", w.Code)
+	}
+}
+
+func TestCheckSameOrigin(t *testing.T) {
+	check, err := checkSameOrigin([]string{"https://app.example.com", "*.example.org", "localhost:3000"})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	tests := []struct {
+		origin string
+		ok     bool
+	}{
+		{"", true},
+		{"https://app.example.com", true},
+		{"http://APP.example.com", true},
+		{"https://example.com", false},
+		{"https://app.example.com.evil.com", false},
+		{"https://evil-app.example.com", false},
+		{"https://app.example.com:8443", false},
+		{"https://www.example.org", true},
+		{"https://a.b.example.org", true},
+		{"https://WWW.Example.ORG", true},
+		{"https://example.org", false},
+		{"https://evil-example.org", false},
+		{"https://example.org.evil.com", false},
+		{"https://wwwexample.org", false},
+		{"http://localhost:3000", true},
+		{"http://localhost:3001", false},
+		{"null", false},
+		{"%zz", false},
+	}
+
+	for _, tt := range tests {
+		r := httptest.NewRequest(http.MethodGet, "/", nil)
+		if tt.origin != "" {
+			r.Header.Set("Origin", tt.origin)
+		}
+
+		if ok := check(r); ok != tt.ok {
+			t.Errorf("origin %q allowed %v, want %v", tt.origin, ok, tt.ok)
+		}
+	}
+}
+
+func TestCheckSameOriginAny(t *testing.T) {
+	for _, origins := range [][]string{nil, {"*"}} {
+		check, err := checkSameOrigin(origins)
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		r := httptest.NewRequest(http.MethodGet, "/", nil)
+		r.Header.Set("Origin", "https://evil.com")
+		if !check(r) {
+			t.Errorf("origins %v refused any origin", origins)
+		}
+	}
+
+	if _, err := checkSameOrigin([]string{"https://"}); err == nil {
+		t.Error("origin without host accepted")
+	}
+}
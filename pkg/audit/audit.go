@@ -0,0 +1,48 @@
+package audit
+
+import (
+	"time"
+)
+
+// Audited events.
+const (
+	EventSubscribe = "subscribe"
+	EventAdmin     = "admin"
+)
+
+const (
+	DecisionAllow = "allow"
+	DecisionDeny  = "deny"
+)
+
+// Record is a structured audit entry, fields not relevant to its event are
+// left empty.
+type Record struct {
+	Time  time.Time `json:"time"`
+	Event string    `json:"event"`
+
+	UID  string `json:"uid,omitempty"`
+	Role string `json:"role,omitempty"`
+	IP   string `json:"ip,omitempty"`
+
+	// Subscription decisions
+	Stream   string `json:"stream,omitempty"`
+	Decision string `json:"decision,omitempty"`
+	Reason   string `json:"reason,omitempty"`
+
+	// Admin API calls
+	Method string `json:"method,omitempty"`
+	Path   string `json:"path,omitempty"`
+	Status int    `json:"status,omitempty"`
+}
+
+// Logger writes audit records. Failures are logged, never returned, so
+// auditing does not interrupt the audited action.
+type Logger interface {
+	Log(r Record)
+}
+
+// Nop discards records.
+type Nop struct{}
+
+func (Nop) Log(Record) {}
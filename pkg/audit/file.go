@@ -0,0 +1,110 @@
+package audit
+
+import (
+	"encoding/json"
+	"fmt"
+	"log"
+	"os"
+	"sync"
+)
+
+// FileLogger appends records as JSON lines to a file, rotated to file.1,
+// file.2... once it reaches maxSize bytes.
+type FileLogger struct {
+	file       string
+	maxSize    int64
+	maxBackups int
+
+	mutex sync.Mutex
+	f     *os.File
+	size  int64
+}
+
+func NewFileLogger(file string, maxSize int64, maxBackups int) (*FileLogger, error) {
+	l := &FileLogger{
+		file:       file,
+		maxSize:    maxSize,
+		maxBackups: maxBackups,
+	}
+
+	if err := l.open(); err != nil {
+		return nil, err
+	}
+
+	return l, nil
+}
+
+func (l *FileLogger) open() error {
+	f, err := os.OpenFile(l.file, os.O_CREATE|os.O_WRONLY|os.O_APPEND, 0o600)
+	if err != nil {
+		return err
+	}
+
+	info, err := f.Stat()
+	if err != nil {
+		f.Close()
+		return err
+	}
+
+	l.f = f
+	l.size = info.Size()
+	return nil
+}
+
+// rotate shifts the backups, dropping the oldest, and starts a new file.
+func (l *FileLogger) rotate() error {
+	l.f.Close()
+	l.f = nil
+
+	for i := l.maxBackups - 1; i > 0; i-- {
+		os.Rename(fmt.Sprintf("%s.%d", l.file, i), fmt.Sprintf("%s.%d", l.file, i+1))
+	}
+
+	if l.maxBackups > 0 {
+		os.Rename(l.file, l.file+".1")
+	} else {
+		os.Remove(l.file)
+	}
+
+	return l.open()
+}
+
+func (l *FileLogger) Log(r Record) {
+	data, err := json.Marshal(r)
+	if err != nil {
+		log.Printf("Failed to encode audit record: %v\n", err)
+		return
+	}
+	data = append(data, '\n')
+
+	l.mutex.Lock()
+	defer l.mutex.Unlock()
+
+	if l.f != nil && l.maxSize > 0 && l.size > 0 && l.size+int64(len(data)) > l.maxSize {
+		err = l.rotate()
+	} else if l.f == nil {
+		// Reopen after a failed rotation
+		err = l.open()
+	}
+
+	if err != nil {
+		log.Printf("Failed to open audit log: %v\n", err)
+		return
+	}
+
+	n, err := l.f.Write(data)
+	l.size += int64(n)
+	if err != nil {
+		log.Printf("Failed to write audit record: %v\n", err)
+	}
+}
+
+func (l *FileLogger) Close() error {
+	l.mutex.Lock()
+	defer l.mutex.Unlock()
+
+	if l.f == nil {
+		return nil
+	}
+	return l.f.Close()
+}
@@ -0,0 +1,47 @@
+package audit
+
+import (
+	"context"
+	"encoding/json"
+	"log"
+
+	"github.com/twmb/franz-go/pkg/kgo"
+)
+
+// KafkaLogger produces records as JSON to a Kafka topic, keyed by uid.
+type KafkaLogger struct {
+	client *kgo.Client
+}
+
+func NewKafkaLogger(brokers []string, topic string) (*KafkaLogger, error) {
+	client, err := kgo.NewClient(
+		kgo.SeedBrokers(brokers...),
+		kgo.DefaultProduceTopic(topic),
+	)
+	if err != nil {
+		return nil, err
+	}
+
+	return &KafkaLogger{client: client}, nil
+}
+
+func (l *KafkaLogger) Log(r Record) {
+	data, err := json.Marshal(r)
+	if err != nil {
+		log.Printf("Failed to encode audit record: %v\n", err)
+		return
+	}
+
+	l.client.Produce(context.Background(), &kgo.Record{Key: []byte(r.UID), Value: data}, func(_ *kgo.Record, err error) {
+		if err != nil {
+			log.Printf("Failed to produce audit record: %v\n", err)
+		}
+	})
+}
+
+// Close flushes the buffered records.
+func (l *KafkaLogger) Close() error {
+	err := l.client.Flush(context.Background())
+	l.client.Close()
+	return err
+}
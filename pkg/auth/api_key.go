@@ -0,0 +1,223 @@
+package auth
+
+import (
+	"crypto/hmac"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log"
+	"net/http"
+	"os"
+	"strconv"
+	"sync"
+	"time"
+)
+
+var (
+	ErrUnknownAPIKey   = errors.New("unknown API key")
+	ErrInvalidNonce    = errors.New("nonce outside of the accepted window")
+	ErrReplayedNonce   = errors.New("nonce already used")
+	ErrInvalidHMAC     = errors.New("invalid signature")
+	ErrMissingHMACAuth = errors.New("missing API key authentication headers")
+)
+
+// Delay between two checks of the API keys file for changes.
+const apiKeysReloadPeriod = 10 * time.Second
+
+// APIKey is an API key credential and the identity it authenticates.
+type APIKey struct {
+	AccessKey string `json:"access_key"`
+	SecretKey string `json:"secret_key"`
+	UID       string `json:"uid"`
+	Role      string `json:"role"`
+	Level     int    `json:"level"`
+}
+
+// Auth returns the identity of the key in the same form as parsed tokens.
+func (k APIKey) Auth() Auth {
+	return Auth{
+		UID:   k.UID,
+		Role:  k.Role,
+		Level: json.Number(strconv.Itoa(k.Level)),
+		State: "active",
+	}
+}
+
+// APIKeyStore looks API keys up by access key.
+type APIKeyStore interface {
+	Lookup(accessKey string) (APIKey, error)
+}
+
+// FileAPIKeyStore reads API keys from a JSON file holding a list of keys.
+// The file is reloaded when it changes.
+type FileAPIKeyStore struct {
+	path string
+
+	mutex     sync.RWMutex
+	keys      map[string]APIKey
+	modTime   time.Time
+	lastCheck time.Time
+}
+
+func NewFileAPIKeyStore(path string) (*FileAPIKeyStore, error) {
+	s := &FileAPIKeyStore{path: path}
+	if err := s.load(); err != nil {
+		return nil, err
+	}
+
+	return s, nil
+}
+
+func (s *FileAPIKeyStore) load() error {
+	info, err := os.Stat(s.path)
+	if err != nil {
+		return err
+	}
+
+	data, err := os.ReadFile(s.path)
+	if err != nil {
+		return err
+	}
+
+	var list []APIKey
+	if err := json.Unmarshal(data, &list); err != nil {
+		return fmt.Errorf("parse API keys: %w", err)
+	}
+
+	keys := make(map[string]APIKey, len(list))
+	for _, k := range list {
+		if k.AccessKey == "" || k.SecretKey == "" || k.UID == "" {
+			return fmt.Errorf("parse API keys: access_key, secret_key and uid are required")
+		}
+		keys[k.AccessKey] = k
+	}
+
+	s.mutex.Lock()
+	s.keys = keys
+	s.modTime = info.ModTime()
+	s.mutex.Unlock()
+
+	return nil
+}
+
+// reloadIfChanged reloads the file when its modification time changed, at
+// most once per apiKeysReloadPeriod. The current keys are kept on failure.
+func (s *FileAPIKeyStore) reloadIfChanged() {
+	s.mutex.Lock()
+	if time.Since(s.lastCheck) < apiKeysReloadPeriod {
+		s.mutex.Unlock()
+		return
+	}
+	s.lastCheck = time.Now()
+	modTime := s.modTime
+	s.mutex.Unlock()
+
+	info, err := os.Stat(s.path)
+	if err != nil || info.ModTime().Equal(modTime) {
+		return
+	}
+
+	if err := s.load(); err != nil {
+		log.Printf("Failed to reload API keys: %v\n", err)
+	}
+}
+
+func (s *FileAPIKeyStore) Lookup(accessKey string) (APIKey, error) {
+	s.reloadIfChanged()
+
+	s.mutex.RLock()
+	defer s.mutex.RUnlock()
+
+	key, ok := s.keys[accessKey]
+	if !ok {
+		return APIKey{}, ErrUnknownAPIKey
+	}
+
+	return key, nil
+}
+
+// nonceCache remembers the nonces used per access key while they are within
+// the accepted window, to reject replayed requests.
+type nonceCache struct {
+	mutex     sync.Mutex
+	seen      map[string]time.Time
+	lastPrune time.Time
+}
+
+// use records the nonce, returning false if it was already used.
+func (c *nonceCache) use(accessKey string, nonce int64, until time.Time) bool {
+	c.mutex.Lock()
+	defer c.mutex.Unlock()
+
+	now := time.Now()
+	if now.Sub(c.lastPrune) > time.Minute {
+		for k, exp := range c.seen {
+			if now.After(exp) {
+				delete(c.seen, k)
+			}
+		}
+		c.lastPrune = now
+	}
+
+	k := accessKey + ":" + strconv.FormatInt(nonce, 10)
+	if exp, ok := c.seen[k]; ok && now.Before(exp) {
+		return false
+	}
+	c.seen[k] = until
+
+	return true
+}
+
+// HMACVerifier checks the X-Auth-Apikey, X-Auth-Nonce and X-Auth-Signature
+// headers produced by APIKeyHMAC. The nonce is a timestamp in milliseconds
+// that must be within Window of the server time and is only accepted once.
+type HMACVerifier struct {
+	Store  APIKeyStore
+	Window time.Duration
+
+	nonces nonceCache
+}
+
+func NewHMACVerifier(store APIKeyStore, window time.Duration) *HMACVerifier {
+	return &HMACVerifier{
+		Store:  store,
+		Window: window,
+		nonces: nonceCache{seen: make(map[string]time.Time)},
+	}
+}
+
+// Verify authenticates the request headers and returns the matching key.
+func (v *HMACVerifier) Verify(header http.Header) (APIKey, error) {
+	accessKey := header.Get("X-Auth-Apikey")
+	nonceStr := header.Get("X-Auth-Nonce")
+	signature := header.Get("X-Auth-Signature")
+	if accessKey == "" || nonceStr == "" || signature == "" {
+		return APIKey{}, ErrMissingHMACAuth
+	}
+
+	nonce, err := strconv.ParseInt(nonceStr, 10, 64)
+	if err != nil {
+		return APIKey{}, ErrInvalidNonce
+	}
+
+	sent := time.Unix(0, nonce*int64(time.Millisecond))
+	if d := time.Since(sent); d > v.Window || d < -v.Window {
+		return APIKey{}, ErrInvalidNonce
+	}
+
+	key, err := v.Store.Lookup(accessKey)
+	if err != nil {
+		return APIKey{}, err
+	}
+
+	expected := NewAPIKeyHMAC(key.AccessKey, key.SecretKey).GetSignature(nonce)
+	if !hmac.Equal([]byte(expected), []byte(signature)) {
+		return APIKey{}, ErrInvalidHMAC
+	}
+
+	if !v.nonces.use(accessKey, nonce, sent.Add(v.Window)) {
+		return APIKey{}, ErrReplayedNonce
+	}
+
+	return key, nil
+}
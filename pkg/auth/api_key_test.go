@@ -0,0 +1,36 @@
+package auth
+
+import (
+	"testing"
+	"time"
+)
+
+func TestNonceCacheExpiry(t *testing.T) {
+	c := nonceCache{seen: make(map[string]time.Time)}
+	now := time.Now()
+
+	if !c.use("access", 1, now.Add(time.Minute)) || c.use("access", 1, now.Add(time.Minute)) {
+		t.Fatal("nonce not recorded once")
+	}
+
+	// Nonces are per access key
+	if !c.use("other", 1, now.Add(time.Minute)) {
+		t.Error("nonce of another key refused")
+	}
+
+	if !c.use("access", 2, now.Add(-time.Second)) || !c.use("access", 2, now.Add(time.Minute)) {
+		t.Error("expired nonce still refused")
+	}
+
+	// Expired nonces are pruned at most once a minute
+	c.use("access", 3, now.Add(-time.Second))
+	c.lastPrune = now.Add(-2 * time.Minute)
+	c.use("access", 4, now.Add(time.Minute))
+
+	if _, ok := c.seen["access:3"]; ok {
+		t.Error("expired nonce not pruned")
+	}
+	if len(c.seen) != 4 {
+		t.Errorf("%d nonces cached, want 4", len(c.seen))
+	}
+}
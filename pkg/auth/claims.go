@@ -0,0 +1,111 @@
+package auth
+
+import (
+	"fmt"
+	"time"
+
+	"github.com/golang-jwt/jwt"
+)
+
+// ClaimPolicy lists the checks applied to the claims of a token once its
+// signature is verified. Zero values disable the matching check.
+type ClaimPolicy struct {
+	// Token must be issued for one of these audiences
+	Audience []string
+
+	// Token must be issued by one of these issuers
+	Issuers []string
+
+	// Token state claim must be "active"
+	RequireActive bool
+
+	// Minimum level required for private streams
+	MinPrivateLevel int
+
+	// Clock skew tolerated when checking exp, nbf and iat
+	Leeway time.Duration
+}
+
+// ClaimError reports why a token was rejected by a ClaimPolicy.
+type ClaimError struct {
+	Reason string
+}
+
+func (e *ClaimError) Error() string {
+	return e.Reason
+}
+
+func claimErrorf(format string, a ...interface{}) error {
+	return &ClaimError{Reason: fmt.Sprintf(format, a...)}
+}
+
+func containsAny(list, values []string) bool {
+	for _, l := range list {
+		for _, v := range values {
+			if l == v {
+				return true
+			}
+		}
+	}
+	return false
+}
+
+// Validate checks the time, audience, issuer and state claims.
+func (p ClaimPolicy) Validate(a Auth, now time.Time) error {
+	leeway := int64(p.Leeway / time.Second)
+	unix := now.Unix()
+
+	if a.ExpiresAt != 0 && unix > a.ExpiresAt+leeway {
+		return claimErrorf("token expired")
+	}
+
+	if a.NotBefore != 0 && unix+leeway < a.NotBefore {
+		return claimErrorf("token not valid yet")
+	}
+
+	if a.IssuedAt != 0 && unix+leeway < a.IssuedAt {
+		return claimErrorf("token used before issued")
+	}
+
+	if len(p.Audience) > 0 && !containsAny(a.Audience, p.Audience) {
+		return claimErrorf("token audience not accepted")
+	}
+
+	if len(p.Issuers) > 0 && !containsAny([]string{a.Issuer}, p.Issuers) {
+		return claimErrorf("token issuer %q not accepted", a.Issuer)
+	}
+
+	if p.RequireActive && a.State != "active" {
+		return claimErrorf("account is not active")
+	}
+
+	return nil
+}
+
+// ValidatePrivate checks the token grants access to private streams.
+func (p ClaimPolicy) ValidatePrivate(a Auth) error {
+	if p.MinPrivateLevel <= 0 {
+		return nil
+	}
+
+	level, err := a.Level.Int64()
+	if err != nil || level < int64(p.MinPrivateLevel) {
+		return claimErrorf("level %d required for private streams", p.MinPrivateLevel)
+	}
+
+	return nil
+}
+
+// ParseWithPolicy verifies the token signature with the key set, then checks
+// its claims against the policy. Time claims are checked by the policy so
+// its leeway applies.
+func ParseWithPolicy(token string, ks *KeySet, policy ClaimPolicy) (Auth, error) {
+	auth := Auth{}
+
+	parser := &jwt.Parser{SkipClaimsValidation: true}
+	if _, err := parser.ParseWithClaims(token, &auth, ks.Keyfunc); err != nil {
+		return auth, err
+	}
+
+	return auth, policy.Validate(auth, time.Now())
+}
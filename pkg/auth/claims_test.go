@@ -0,0 +1,80 @@
+package auth
+
+import (
+	"encoding/json"
+	"errors"
+	"reflect"
+	"testing"
+
+	"github.com/golang-jwt/jwt"
+)
+
+func TestParseWithPolicyAudience(t *testing.T) {
+	key := newECKey(t)
+	ks := NewKeySet()
+	ks.Add("", &key.PublicKey)
+
+	policy := ClaimPolicy{Audience: []string{"rango"}}
+
+	cases := []struct {
+		aud    interface{}
+		accept bool
+	}{
+		{"rango", true},
+		{[]string{"peatio", "rango"}, true},
+		{"peatio", false},
+		{[]string{"peatio", "barong"}, false},
+		{nil, false},
+	}
+
+	for _, c := range cases {
+		claims := jwt.MapClaims{"uid": "UID123"}
+		if c.aud != nil {
+			claims["aud"] = c.aud
+		}
+
+		token, err := jwt.NewWithClaims(jwt.SigningMethodES256, claims).SignedString(key)
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		_, err = ParseWithPolicy(token, ks, policy)
+		if c.accept {
+			if err != nil {
+				t.Errorf("aud %v rejected: %v", c.aud, err)
+			}
+			continue
+		}
+
+		var claimErr *ClaimError
+		if !errors.As(err, &claimErr) {
+			t.Errorf("aud %v: got %v, want an audience rejection", c.aud, err)
+		}
+	}
+}
+
+func TestAudienceUnmarshal(t *testing.T) {
+	cases := map[string]Audience{
+		`{"aud":"rango"}`:            {"rango"},
+		`{"aud":["rango","barong"]}`: {"rango", "barong"},
+		`{"aud":null}`:               nil,
+		`{}`:                         nil,
+	}
+
+	for data, want := range cases {
+		var a Auth
+		if err := json.Unmarshal([]byte(data), &a); err != nil {
+			t.Errorf("%s: %v", data, err)
+			continue
+		}
+
+		if !reflect.DeepEqual(a.Audience, want) {
+			t.Errorf("%s: audience %#v, want %#v", data, a.Audience, want)
+		}
+	}
+
+	var a Auth
+	if err := json.Unmarshal([]byte(`{"aud":12}`), &a); err == nil {
+		t.Error("numeric audience accepted")
+	}
+}
@@ -23,10 +23,14 @@ func NewAPIKeyHMAC(accessKey, secretKey string) *APIKeyHMAC {
 	}
 }
 
+func nowMillis() int64 {
+	return time.Now().UnixNano() / int64(time.Millisecond)
+}
+
 // GetSignature return a signature for the given nonce, if nonce is zero it use the current time in millisecond
 func (key *APIKeyHMAC) GetSignature(nonce int64) string {
 	if nonce == 0 {
-		nonce = int64(time.Now().UnixNano() * 1000000)
+		nonce = nowMillis()
 	}
 	mac := hmac.New(sha256.New, []byte(key.SecretKey))
 	mac.Write([]byte(fmt.Sprintf("%d%s", nonce, key.AccessKey)))
@@ -36,7 +40,7 @@ func (key *APIKeyHMAC) GetSignature(nonce int64) string {
 // GetSignedHeader returns a header with valid HMAC authorization fields
 func (key *APIKeyHMAC) GetSignedHeader(nonce int64) http.Header {
 	if nonce == 0 {
-		nonce = int64(time.Now().UnixNano() * 1000000)
+		nonce = nowMillis()
 	}
 
 	return http.Header{
@@ -0,0 +1,213 @@
+package auth
+
+import (
+	"crypto"
+	"crypto/ecdsa"
+	"crypto/ed25519"
+	"crypto/elliptic"
+	"crypto/rsa"
+	"encoding/base64"
+	"encoding/json"
+	"fmt"
+	"io"
+	"math/big"
+	"net/http"
+	"os"
+	"strings"
+	"time"
+)
+
+// Timeout of a JWKS HTTP request.
+const jwksTimeout = 10 * time.Second
+
+var jwksClient = &http.Client{Timeout: jwksTimeout}
+
+// JWK is a JSON web key as published in a key set.
+type JWK struct {
+	Kty string `json:"kty"`
+	Kid string `json:"kid"`
+	Use string `json:"use,omitempty"`
+	Alg string `json:"alg,omitempty"`
+
+	// RSA public key
+	N string `json:"n,omitempty"`
+	E string `json:"e,omitempty"`
+
+	// EC and OKP public keys
+	Crv string `json:"crv,omitempty"`
+	X   string `json:"x,omitempty"`
+	Y   string `json:"y,omitempty"`
+}
+
+// JWKS is a JSON web key set.
+type JWKS struct {
+	Keys []JWK `json:"keys"`
+}
+
+func isURL(source string) bool {
+	return strings.HasPrefix(source, "http://") || strings.HasPrefix(source, "https://")
+}
+
+// fetchJWKS reads a JWKS from a file or URL. For URLs the etag of the last
+// response is sent back, and a nil body is returned when it did not change.
+func fetchJWKS(source, etag string) ([]byte, string, error) {
+	if !isURL(source) {
+		data, err := os.ReadFile(source)
+		return data, "", err
+	}
+
+	req, err := http.NewRequest(http.MethodGet, source, nil)
+	if err != nil {
+		return nil, "", err
+	}
+
+	if etag != "" {
+		req.Header.Set("If-None-Match", etag)
+	}
+
+	res, err := jwksClient.Do(req)
+	if err != nil {
+		return nil, "", err
+	}
+	defer res.Body.Close()
+
+	switch res.StatusCode {
+	case http.StatusNotModified:
+		return nil, etag, nil
+	case http.StatusOK:
+	default:
+		return nil, "", fmt.Errorf("fetch JWKS: unexpected status %s", res.Status)
+	}
+
+	data, err := io.ReadAll(res.Body)
+	if err != nil {
+		return nil, "", err
+	}
+
+	return data, res.Header.Get("ETag"), nil
+}
+
+func decodeBigInt(s string) (*big.Int, error) {
+	b, err := base64.RawURLEncoding.DecodeString(strings.TrimRight(s, "="))
+	if err != nil {
+		return nil, err
+	}
+
+	return new(big.Int).SetBytes(b), nil
+}
+
+func (k JWK) rsaPublicKey() (*rsa.PublicKey, error) {
+	n, err := decodeBigInt(k.N)
+	if err != nil {
+		return nil, fmt.Errorf("key %q: invalid modulus: %w", k.Kid, err)
+	}
+
+	e, err := decodeBigInt(k.E)
+	if err != nil {
+		return nil, fmt.Errorf("key %q: invalid exponent: %w", k.Kid, err)
+	}
+
+	if !e.IsInt64() {
+		return nil, fmt.Errorf("key %q: exponent too large", k.Kid)
+	}
+
+	return &rsa.PublicKey{
+		N: n,
+		E: int(e.Int64()),
+	}, nil
+}
+
+func (k JWK) ecPublicKey() (*ecdsa.PublicKey, error) {
+	var curve elliptic.Curve
+	switch k.Crv {
+	case "P-256":
+		curve = elliptic.P256()
+	case "P-384":
+		curve = elliptic.P384()
+	case "P-521":
+		curve = elliptic.P521()
+	default:
+		return nil, fmt.Errorf("key %q: unsupported curve %s", k.Kid, k.Crv)
+	}
+
+	x, err := decodeBigInt(k.X)
+	if err != nil {
+		return nil, fmt.Errorf("key %q: invalid x: %w", k.Kid, err)
+	}
+
+	y, err := decodeBigInt(k.Y)
+	if err != nil {
+		return nil, fmt.Errorf("key %q: invalid y: %w", k.Kid, err)
+	}
+
+	if !curve.IsOnCurve(x, y) {
+		return nil, fmt.Errorf("key %q: point is not on curve %s", k.Kid, k.Crv)
+	}
+
+	return &ecdsa.PublicKey{
+		Curve: curve,
+		X:     x,
+		Y:     y,
+	}, nil
+}
+
+func (k JWK) edPublicKey() (ed25519.PublicKey, error) {
+	if k.Crv != "Ed25519" {
+		return nil, fmt.Errorf("key %q: unsupported curve %s", k.Kid, k.Crv)
+	}
+
+	x, err := base64.RawURLEncoding.DecodeString(strings.TrimRight(k.X, "="))
+	if err != nil || len(x) != ed25519.PublicKeySize {
+		return nil, fmt.Errorf("key %q: invalid x", k.Kid)
+	}
+
+	return ed25519.PublicKey(x), nil
+}
+
+// ParseJWKS decodes the signing keys of a JSON web key set by kid. The alg of
+// a key, when published, is the only algorithm accepted for it. Keys for
+// other uses or of unsupported types are skipped.
+func ParseJWKS(data []byte) (map[string]PublicKey, error) {
+	var set JWKS
+	if err := json.Unmarshal(data, &set); err != nil {
+		return nil, fmt.Errorf("parse JWKS: %w", err)
+	}
+
+	keys := make(map[string]PublicKey, len(set.Keys))
+	for _, k := range set.Keys {
+		if k.Use != "" && k.Use != "sig" {
+			continue
+		}
+
+		var key crypto.PublicKey
+		var err error
+
+		switch k.Kty {
+		case "RSA":
+			key, err = k.rsaPublicKey()
+		case "EC":
+			key, err = k.ecPublicKey()
+		case "OKP":
+			key, err = k.edPublicKey()
+		default:
+			continue
+		}
+
+		if err != nil {
+			return nil, err
+		}
+
+		pk := PublicKey{Key: key}
+		if k.Alg != "" {
+			pk.Algorithms = []string{k.Alg}
+		}
+
+		keys[k.Kid] = pk
+	}
+
+	if len(keys) == 0 {
+		return nil, fmt.Errorf("parse JWKS: no signing keys")
+	}
+
+	return keys, nil
+}
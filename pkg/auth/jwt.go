@@ -1,6 +1,7 @@
 package auth
 
 import (
+	"crypto"
 	"crypto/rsa"
 	"encoding/json"
 	"strconv"
@@ -17,17 +18,42 @@ type Auth struct {
 	Role       string      `json:"role"`
 	ReferralID json.Number `json:"referral_id"`
 	Level      json.Number `json:"level"`
-	Audience   []string    `json:"aud,omitempty"`
+	Audience   Audience    `json:"aud,omitempty"`
 
 	jwt.StandardClaims
 }
 
+// Audience is the aud claim, which RFC 7519 allows as a single string or an
+// array of strings.
+type Audience []string
+
+func (a *Audience) UnmarshalJSON(data []byte) error {
+	if string(data) == "null" {
+		*a = nil
+		return nil
+	}
+
+	var single string
+	if err := json.Unmarshal(data, &single); err == nil {
+		*a = Audience{single}
+		return nil
+	}
+
+	var list []string
+	if err := json.Unmarshal(data, &list); err != nil {
+		return err
+	}
+	*a = list
+
+	return nil
+}
+
 // ParseAndValidate parses token and validates it's jwt signature with given key.
 func ParseAndValidate(token string, key *rsa.PublicKey) (Auth, error) {
 	auth := Auth{}
 
 	_, err := jwt.ParseWithClaims(token, &auth, func(t *jwt.Token) (interface{}, error) {
-		return key, nil
+		return PublicKey{Key: key}.verificationKey(t)
 	})
 
 	return auth, err
@@ -49,8 +75,14 @@ func appendClaims(defaultClaims, customClaims jwt.MapClaims) jwt.MapClaims {
 	return defaultClaims
 }
 
-// ForgeToken creates a valid JWT signed by the given private key
-func ForgeToken(uid, email, role string, level int, key *rsa.PrivateKey, customClaims jwt.MapClaims) (string, error) {
+// ForgeToken creates a valid JWT signed by the given RSA, ECDSA or Ed25519
+// private key, with the algorithm matching the key.
+func ForgeToken(uid, email, role string, level int, key crypto.Signer, customClaims jwt.MapClaims) (string, error) {
+	method, err := signingMethod(key)
+	if err != nil {
+		return "", err
+	}
+
 	claims := appendClaims(jwt.MapClaims{
 		"iat":         time.Now().Unix(),
 		"jti":         strconv.FormatInt(time.Now().Unix(), 10),
@@ -66,7 +98,7 @@ func ForgeToken(uid, email, role string, level int, key *rsa.PrivateKey, customC
 		"referral_id": nil,
 	}, customClaims)
 
-	t := jwt.NewWithClaims(jwt.SigningMethodRS256, claims)
+	t := jwt.NewWithClaims(method, claims)
 
 	return t.SignedString(key)
 }
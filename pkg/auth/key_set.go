@@ -0,0 +1,223 @@
+package auth
+
+import (
+	"crypto"
+	"errors"
+	"fmt"
+	"log"
+	"sync"
+	"time"
+
+	"github.com/golang-jwt/jwt"
+)
+
+// Minimum delay between two refreshes triggered by an unknown kid.
+const minJWKSRefreshInterval = 30 * time.Second
+
+// PublicKey is a token verification key with the signing algorithms it
+// accepts, DefaultAlgorithms of the key when empty.
+type PublicKey struct {
+	Key        crypto.PublicKey
+	Algorithms []string
+}
+
+// verificationKey returns the key to verify t with, after checking the token
+// algorithm is allowed for it.
+func (k PublicKey) verificationKey(t *jwt.Token) (interface{}, error) {
+	algs := k.Algorithms
+	if len(algs) == 0 {
+		algs = DefaultAlgorithms(k.Key)
+	}
+
+	alg := t.Method.Alg()
+	for _, a := range algs {
+		if a == alg {
+			return k.Key, nil
+		}
+	}
+
+	return nil, fmt.Errorf("algorithm %s is not allowed for this key", alg)
+}
+
+// KeySet holds the public keys tokens may be signed with, selected by the kid
+// header. Keys can be static or loaded from a JWKS file or URL and refreshed,
+// so signing keys can rotate without a restart.
+type KeySet struct {
+	mutex sync.RWMutex
+
+	// Keys loaded from the JWKS, replaced on refresh
+	keys map[string]PublicKey
+
+	// Keys added with Add, kept across refreshes
+	static map[string]PublicKey
+
+	// JWKS file path or URL, empty for static keys
+	source    string
+	etag      string
+	lastFetch time.Time
+}
+
+func NewKeySet() *KeySet {
+	return &KeySet{
+		keys:   make(map[string]PublicKey),
+		static: make(map[string]PublicKey),
+	}
+}
+
+// NewJWKSKeySet creates a key set loaded from a JWKS file path or http(s) URL.
+func NewJWKSKeySet(source string) (*KeySet, error) {
+	ks := NewKeySet()
+	ks.source = source
+
+	if err := ks.Refresh(); err != nil {
+		return nil, err
+	}
+
+	return ks, nil
+}
+
+// Add registers a static key accepting the given algorithms, or its default
+// ones. An empty kid makes it the default key for tokens without kid header.
+// Static keys are kept when the JWKS is refreshed.
+func (ks *KeySet) Add(kid string, key crypto.PublicKey, algs ...string) {
+	ks.mutex.Lock()
+	defer ks.mutex.Unlock()
+
+	ks.static[kid] = PublicKey{
+		Key:        key,
+		Algorithms: algs,
+	}
+}
+
+func (ks *KeySet) Len() int {
+	ks.mutex.RLock()
+	defer ks.mutex.RUnlock()
+
+	return len(ks.keys) + len(ks.static)
+}
+
+// lookup returns the JWKS or static key of kid. Tokens without kid use the
+// only key of the set when there is a single one.
+func (ks *KeySet) lookup(kid string) (PublicKey, bool) {
+	ks.mutex.RLock()
+	defer ks.mutex.RUnlock()
+
+	if key, ok := ks.keys[kid]; ok {
+		return key, true
+	}
+
+	if key, ok := ks.static[kid]; ok {
+		return key, true
+	}
+
+	if kid == "" && len(ks.keys)+len(ks.static) == 1 {
+		for _, key := range ks.keys {
+			return key, true
+		}
+		for _, key := range ks.static {
+			return key, true
+		}
+	}
+
+	return PublicKey{}, false
+}
+
+// Key returns the key for kid. An unknown kid triggers a refresh of the JWKS,
+// at most once per minJWKSRefreshInterval, in case keys were just rotated.
+// Concurrent lookups of unknown kids share a single refresh.
+func (ks *KeySet) Key(kid string) (PublicKey, error) {
+	if key, ok := ks.lookup(kid); ok {
+		return key, nil
+	}
+
+	ks.mutex.Lock()
+	stale := ks.source != "" && time.Since(ks.lastFetch) > minJWKSRefreshInterval
+	if stale {
+		// Claims the refresh before fetching, so lookups racing this one
+		// do not fetch too
+		ks.lastFetch = time.Now()
+	}
+	ks.mutex.Unlock()
+
+	if stale {
+		if err := ks.Refresh(); err != nil {
+			log.Printf("Failed to refresh JWKS: %v\n", err)
+		}
+
+		if key, ok := ks.lookup(kid); ok {
+			return key, nil
+		}
+	}
+
+	return PublicKey{}, fmt.Errorf("unknown signing key %q", kid)
+}
+
+// Keyfunc selects the verification key of a token, for use with jwt.Parse.
+// Tokens signed with an algorithm the key does not allow are rejected.
+func (ks *KeySet) Keyfunc(t *jwt.Token) (interface{}, error) {
+	kid, _ := t.Header["kid"].(string)
+
+	key, err := ks.Key(kid)
+	if err != nil {
+		return nil, err
+	}
+
+	return key.verificationKey(t)
+}
+
+// Refresh reloads the keys from the JWKS source. The previous keys are kept
+// when loading fails or the source did not change. Static keys are never
+// replaced.
+func (ks *KeySet) Refresh() error {
+	ks.mutex.RLock()
+	source, etag := ks.source, ks.etag
+	ks.mutex.RUnlock()
+
+	if source == "" {
+		return errors.New("key set has no JWKS source")
+	}
+
+	data, newEtag, err := fetchJWKS(source, etag)
+
+	ks.mutex.Lock()
+	defer ks.mutex.Unlock()
+
+	ks.lastFetch = time.Now()
+	if err != nil {
+		return err
+	}
+
+	// Not modified since the last fetch
+	if data == nil {
+		return nil
+	}
+
+	keys, err := ParseJWKS(data)
+	if err != nil {
+		return err
+	}
+
+	ks.keys = keys
+	ks.etag = newEtag
+
+	return nil
+}
+
+// RefreshEvery reloads the JWKS on the given period, it never returns.
+func (ks *KeySet) RefreshEvery(period time.Duration) {
+	for range time.Tick(period) {
+		if err := ks.Refresh(); err != nil {
+			log.Printf("Failed to refresh JWKS: %v\n", err)
+		}
+	}
+}
+
+// ParseAndValidateKeySet parses token and validates it's jwt signature with
+// the key of the set matching its kid.
+func ParseAndValidateKeySet(token string, ks *KeySet) (Auth, error) {
+	auth := Auth{}
+
+	_, err := jwt.ParseWithClaims(token, &auth, ks.Keyfunc)
+
+	return auth, err
+}
@@ -0,0 +1,255 @@
+package auth
+
+import (
+	"crypto/ecdsa"
+	"crypto/ed25519"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/rsa"
+	"encoding/base64"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/golang-jwt/jwt"
+)
+
+func newECKey(t *testing.T) *ecdsa.PrivateKey {
+	t.Helper()
+
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	return key
+}
+
+// writeJWKS writes the public keys by kid as a JWKS file.
+func writeJWKS(t *testing.T, path string, keys map[string]*ecdsa.PrivateKey) {
+	t.Helper()
+
+	set := JWKS{}
+	for kid, key := range keys {
+		set.Keys = append(set.Keys, JWK{
+			Kty: "EC",
+			Kid: kid,
+			Crv: "P-256",
+			X:   base64.RawURLEncoding.EncodeToString(key.X.FillBytes(make([]byte, 32))),
+			Y:   base64.RawURLEncoding.EncodeToString(key.Y.FillBytes(make([]byte, 32))),
+		})
+	}
+
+	data, err := json.Marshal(set)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if err := os.WriteFile(path, data, 0o644); err != nil {
+		t.Fatal(err)
+	}
+}
+
+func signWithKid(t *testing.T, key *ecdsa.PrivateKey, kid string) string {
+	t.Helper()
+
+	token := jwt.NewWithClaims(jwt.SigningMethodES256, jwt.MapClaims{"uid": "UID123"})
+	if kid != "" {
+		token.Header["kid"] = kid
+	}
+
+	signed, err := token.SignedString(key)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	return signed
+}
+
+func TestKeySetRefreshKeepsStaticKeys(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "jwks.json")
+	rotated, next, static := newECKey(t), newECKey(t), newECKey(t)
+	writeJWKS(t, path, map[string]*ecdsa.PrivateKey{"k1": rotated})
+
+	ks, err := NewJWKSKeySet(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	ks.Add("", &static.PublicKey)
+
+	verify := func(token string) error {
+		_, err := ParseAndValidateKeySet(token, ks)
+		return err
+	}
+
+	if err := verify(signWithKid(t, static, "")); err != nil {
+		t.Fatalf("static key before refresh: %v", err)
+	}
+
+	writeJWKS(t, path, map[string]*ecdsa.PrivateKey{"k2": next})
+	if err := ks.Refresh(); err != nil {
+		t.Fatal(err)
+	}
+
+	if err := verify(signWithKid(t, static, "")); err != nil {
+		t.Errorf("static key after refresh: %v", err)
+	}
+	if err := verify(signWithKid(t, next, "k2")); err != nil {
+		t.Errorf("refreshed key: %v", err)
+	}
+	if err := verify(signWithKid(t, rotated, "k1")); err == nil {
+		t.Error("rotated out key still accepted")
+	}
+	if n := ks.Len(); n != 2 {
+		t.Errorf("Len() = %d, want 2", n)
+	}
+}
+
+func TestKeySetUnknownKidKeepsStaticKeys(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "jwks.json")
+	static := newECKey(t)
+	writeJWKS(t, path, map[string]*ecdsa.PrivateKey{"k1": newECKey(t)})
+
+	ks, err := NewJWKSKeySet(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	ks.Add("", &static.PublicKey)
+
+	// Lets the unknown kid trigger a refresh
+	ks.lastFetch = ks.lastFetch.Add(-2 * minJWKSRefreshInterval)
+	writeJWKS(t, path, map[string]*ecdsa.PrivateKey{"k2": newECKey(t)})
+
+	if _, err := ParseAndValidateKeySet(signWithKid(t, newECKey(t), "unknown"), ks); err == nil {
+		t.Fatal("token of an unknown kid accepted")
+	}
+
+	if _, err := ParseAndValidateKeySet(signWithKid(t, static, ""), ks); err != nil {
+		t.Errorf("static key after unknown kid refresh: %v", err)
+	}
+}
+
+func TestKeySetSingleKeyWithoutKid(t *testing.T) {
+	key := newECKey(t)
+	ks := NewKeySet()
+	ks.Add("k1", &key.PublicKey)
+
+	if _, err := ParseAndValidateKeySet(signWithKid(t, key, ""), ks); err != nil {
+		t.Errorf("token without kid and a single key: %v", err)
+	}
+}
+
+func TestKeySetUnknownKidsShareRefresh(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "jwks.json")
+	writeJWKS(t, path, map[string]*ecdsa.PrivateKey{"k1": newECKey(t)})
+
+	var fetches atomic.Int32
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		fetches.Add(1)
+		// Keeps the refresh in flight while the other lookups arrive
+		time.Sleep(50 * time.Millisecond)
+		http.ServeFile(w, r, path)
+	}))
+	defer srv.Close()
+
+	ks, err := NewJWKSKeySet(srv.URL)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// Lets the unknown kids trigger a refresh
+	ks.lastFetch = ks.lastFetch.Add(-2 * minJWKSRefreshInterval)
+	fetches.Store(0)
+
+	var wg sync.WaitGroup
+	for i := 0; i < 50; i++ {
+		wg.Add(1)
+		go func(i int) {
+			defer wg.Done()
+			if _, err := ks.Key(fmt.Sprintf("unknown%d", i)); err == nil {
+				t.Errorf("unknown%d found", i)
+			}
+		}(i)
+	}
+	wg.Wait()
+
+	if n := fetches.Load(); n != 1 {
+		t.Errorf("%d JWKS fetches, want 1", n)
+	}
+}
+
+func signWith(t *testing.T, method jwt.SigningMethod, key interface{}) string {
+	t.Helper()
+
+	signed, err := jwt.NewWithClaims(method, jwt.MapClaims{"uid": "UID123"}).SignedString(key)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	return signed
+}
+
+func TestKeySetRejectsDisallowedAlgorithms(t *testing.T) {
+	ec := newECKey(t)
+	ec384, err := ecdsa.GenerateKey(elliptic.P384(), rand.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+	rsaKey, err := rsa.GenerateKey(rand.Reader, 2048)
+	if err != nil {
+		t.Fatal(err)
+	}
+	_, edKey, err := ed25519.GenerateKey(rand.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	ks := KeyStore{PublicKey: &ec.PublicKey}
+	pemKey, err := ks.PublicKeyPEM()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// The public key only accepts ES256, by default and explicitly
+	for _, algs := range [][]string{nil, {"ES256"}} {
+		keys := NewKeySet()
+		keys.Add("", &ec.PublicKey, algs...)
+
+		if _, err := ParseAndValidateKeySet(signWith(t, jwt.SigningMethodES256, ec), keys); err != nil {
+			t.Fatalf("%v: ES256 token refused: %v", algs, err)
+		}
+
+		tokens := map[string]string{
+			// The public key used as HMAC secret
+			"HS256": signWith(t, jwt.SigningMethodHS256, pemKey),
+			"RS256": signWith(t, jwt.SigningMethodRS256, rsaKey),
+			"ES384": signWith(t, jwt.SigningMethodES384, ec384),
+			"EdDSA": signWith(t, jwt.SigningMethodEdDSA, edKey),
+			"none":  signWith(t, jwt.SigningMethodNone, jwt.UnsafeAllowNoneSignatureType),
+		}
+
+		for alg, token := range tokens {
+			if _, err := ParseAndValidateKeySet(token, keys); err == nil {
+				t.Errorf("%v: %s token accepted by an ES256 key", algs, alg)
+			}
+		}
+	}
+
+	// An explicit list narrows the defaults of the key
+	keys := NewKeySet()
+	keys.Add("", &rsaKey.PublicKey, "RS512")
+
+	if _, err := ParseAndValidateKeySet(signWith(t, jwt.SigningMethodRS256, rsaKey), keys); err == nil {
+		t.Error("RS256 token accepted by an RS512 only key")
+	}
+	if _, err := ParseAndValidateKeySet(signWith(t, jwt.SigningMethodRS512, rsaKey), keys); err != nil {
+		t.Errorf("RS512 token refused: %v", err)
+	}
+}
@@ -1,20 +1,28 @@
 package auth
 
 import (
+	"crypto"
+	"crypto/ecdsa"
+	"crypto/ed25519"
+	"crypto/elliptic"
 	"crypto/rand"
 	"crypto/rsa"
 	"crypto/x509"
 	"encoding/base64"
 	"encoding/pem"
+	"fmt"
 	"io/ioutil"
 	"os"
 
 	"github.com/golang-jwt/jwt"
 )
 
+// KeyStore holds a signing key pair. RS256 keys are used unless Algorithm is
+// set to ES256, ES384 or EdDSA before generating.
 type KeyStore struct {
-	PublicKey  *rsa.PublicKey
-	PrivateKey *rsa.PrivateKey
+	Algorithm  string
+	PublicKey  crypto.PublicKey
+	PrivateKey crypto.Signer
 }
 
 func fileExist(path string) bool {
@@ -23,15 +31,22 @@ func fileExist(path string) bool {
 }
 
 func LoadOrGenerateKeys(privPath, pubPath string) (*KeyStore, error) {
+	return LoadOrGenerateKeysFor("", privPath, pubPath)
+}
+
+// LoadOrGenerateKeysFor is LoadOrGenerateKeys for keys of the given algorithm.
+func LoadOrGenerateKeysFor(alg, privPath, pubPath string) (*KeyStore, error) {
 	var err error
-	ks := &KeyStore{}
+	ks := &KeyStore{Algorithm: alg}
 
 	if fileExist(privPath) {
 		if err = ks.LoadPrivateKey(privPath); err != nil {
 			return ks, err
 		}
 	} else {
-		ks.GenerateKeys()
+		if err = ks.GenerateKeys(); err != nil {
+			return ks, err
+		}
 		if err = ks.SavePrivateKey(privPath); err != nil {
 			return ks, err
 		}
@@ -43,7 +58,7 @@ func LoadOrGenerateKeys(privPath, pubPath string) (*KeyStore, error) {
 		}
 	} else {
 		if ks.PublicKey == nil {
-			ks.PublicKey = &ks.PrivateKey.PublicKey
+			ks.PublicKey = ks.PrivateKey.Public()
 		}
 
 		if err = ks.SavePublicKey(pubPath); err != nil {
@@ -54,13 +69,49 @@ func LoadOrGenerateKeys(privPath, pubPath string) (*KeyStore, error) {
 	return ks, nil
 }
 
+// parsePublicKeyPEM parses a RSA, ECDSA or Ed25519 public key.
+func parsePublicKeyPEM(data []byte) (crypto.PublicKey, error) {
+	if key, err := jwt.ParseRSAPublicKeyFromPEM(data); err == nil {
+		return key, nil
+	}
+
+	if key, err := jwt.ParseECPublicKeyFromPEM(data); err == nil {
+		return key, nil
+	}
+
+	if key, err := jwt.ParseEdPublicKeyFromPEM(data); err == nil {
+		return key, nil
+	}
+
+	return nil, fmt.Errorf("unsupported public key")
+}
+
+// parsePrivateKeyPEM parses a RSA, ECDSA or Ed25519 private key.
+func parsePrivateKeyPEM(data []byte) (crypto.Signer, error) {
+	if key, err := jwt.ParseRSAPrivateKeyFromPEM(data); err == nil {
+		return key, nil
+	}
+
+	if key, err := jwt.ParseECPrivateKeyFromPEM(data); err == nil {
+		return key, nil
+	}
+
+	if key, err := jwt.ParseEdPrivateKeyFromPEM(data); err == nil {
+		if signer, ok := key.(crypto.Signer); ok {
+			return signer, nil
+		}
+	}
+
+	return nil, fmt.Errorf("unsupported private key")
+}
+
 func (ks *KeyStore) LoadPublicKeyFromFile(path string) error {
 	pem, err := ioutil.ReadFile(path)
 	if err != nil {
 		return err
 	}
 
-	key, err := jwt.ParseRSAPublicKeyFromPEM(pem)
+	key, err := parsePublicKeyPEM(pem)
 	if err != nil {
 		return err
 	}
@@ -76,7 +127,7 @@ func (ks *KeyStore) LoadPublicKeyFromString(str string) error {
 		return err
 	}
 
-	key, err := jwt.ParseRSAPublicKeyFromPEM(pem)
+	key, err := parsePublicKeyPEM(pem)
 	if err != nil {
 		return err
 	}
@@ -91,7 +142,7 @@ func (ks *KeyStore) LoadPrivateKey(path string) error {
 		return err
 	}
 
-	key, err := jwt.ParseRSAPrivateKeyFromPEM(pem)
+	key, err := parsePrivateKeyPEM(pem)
 	if err != nil {
 		return err
 	}
@@ -102,52 +153,91 @@ func (ks *KeyStore) LoadPrivateKey(path string) error {
 
 func (ks *KeyStore) GenerateKeys() error {
 	reader := rand.Reader
-	bitSize := 2048
-	key, err := rsa.GenerateKey(reader, bitSize)
+	var key crypto.Signer
+	var err error
+
+	switch ks.Algorithm {
+	case "", "RS256":
+		bitSize := 2048
+		key, err = rsa.GenerateKey(reader, bitSize)
+	case "ES256":
+		key, err = ecdsa.GenerateKey(elliptic.P256(), reader)
+	case "ES384":
+		key, err = ecdsa.GenerateKey(elliptic.P384(), reader)
+	case "EdDSA":
+		_, key, err = ed25519.GenerateKey(reader)
+	default:
+		err = fmt.Errorf("unsupported algorithm %s", ks.Algorithm)
+	}
 
 	if err != nil {
 		return err
 	}
 
 	ks.PrivateKey = key
-	ks.PublicKey = &key.PublicKey
+	ks.PublicKey = key.Public()
 
 	return nil
 }
 
 func (ks *KeyStore) SavePrivateKey(path string) error {
-	file, err := os.Create(path)
+	var key *pem.Block
+
+	switch k := ks.PrivateKey.(type) {
+	case *rsa.PrivateKey:
+		key = &pem.Block{
+			Type:  "RSA PRIVATE KEY",
+			Bytes: x509.MarshalPKCS1PrivateKey(k),
+		}
+	case *ecdsa.PrivateKey:
+		bytes, err := x509.MarshalECPrivateKey(k)
+		if err != nil {
+			return err
+		}
+		key = &pem.Block{
+			Type:  "EC PRIVATE KEY",
+			Bytes: bytes,
+		}
+	default:
+		bytes, err := x509.MarshalPKCS8PrivateKey(k)
+		if err != nil {
+			return err
+		}
+		key = &pem.Block{
+			Type:  "PRIVATE KEY",
+			Bytes: bytes,
+		}
+	}
+
+	file, err := os.OpenFile(path, os.O_CREATE|os.O_WRONLY|os.O_TRUNC, 0600)
 	if err != nil {
 		return err
 	}
 
 	defer file.Close()
 
-	var key = &pem.Block{
-		Type:  "RSA PRIVATE KEY",
-		Bytes: x509.MarshalPKCS1PrivateKey(ks.PrivateKey),
-	}
-
 	return pem.Encode(file, key)
 }
 
-func (ks *KeyStore) SavePublicKey(path string) error {
+// PublicKeyPEM encodes the public key as PEM, its base64 being the
+// JWT_PUBLIC_KEY format.
+func (ks *KeyStore) PublicKeyPEM() ([]byte, error) {
 	bytes, err := x509.MarshalPKIXPublicKey(ks.PublicKey)
 	if err != nil {
-		return err
+		return nil, err
 	}
 
-	var key = &pem.Block{
+	return pem.EncodeToMemory(&pem.Block{
 		Type:  "PUBLIC KEY",
 		Bytes: bytes,
-	}
+	}), nil
+}
 
-	file, err := os.Create(path)
+func (ks *KeyStore) SavePublicKey(path string) error {
+	key, err := ks.PublicKeyPEM()
 	if err != nil {
 		return err
 	}
 
-	defer file.Close()
-
-	return pem.Encode(file, key)
+	return ioutil.WriteFile(path, key, 0644)
 }
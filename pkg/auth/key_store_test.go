@@ -0,0 +1,67 @@
+package auth
+
+import (
+	"crypto"
+	"encoding/base64"
+	"path/filepath"
+	"testing"
+)
+
+func TestKeyStoreRoundTrip(t *testing.T) {
+	for _, alg := range []string{"RS256", "ES256", "ES384", "EdDSA"} {
+		t.Run(alg, func(t *testing.T) {
+			dir := t.TempDir()
+			priv, pub := filepath.Join(dir, "key.pem"), filepath.Join(dir, "key.pub")
+
+			generated, err := LoadOrGenerateKeysFor(alg, priv, pub)
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			loaded, err := LoadOrGenerateKeysFor(alg, priv, pub)
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			if !loaded.PrivateKey.(interface{ Equal(crypto.PrivateKey) bool }).Equal(generated.PrivateKey) {
+				t.Error("loaded private key differs from the generated one")
+			}
+			if !loaded.PublicKey.(interface{ Equal(crypto.PublicKey) bool }).Equal(generated.PublicKey) {
+				t.Error("loaded public key differs from the generated one")
+			}
+
+			token, err := ForgeToken("UID123", "user@example.com", "member", 1, loaded.PrivateKey, nil)
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			keys := NewKeySet()
+			keys.Add("", loaded.PublicKey)
+
+			if _, err := ParseAndValidateKeySet(token, keys); err != nil {
+				t.Errorf("token signed with the loaded key refused: %v", err)
+			}
+		})
+	}
+}
+
+func TestKeyStorePublicKeyFromString(t *testing.T) {
+	ks := &KeyStore{Algorithm: "EdDSA"}
+	if err := ks.GenerateKeys(); err != nil {
+		t.Fatal(err)
+	}
+
+	data, err := ks.PublicKeyPEM()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	loaded := &KeyStore{}
+	if err := loaded.LoadPublicKeyFromString(base64.StdEncoding.EncodeToString(data)); err != nil {
+		t.Fatal(err)
+	}
+
+	if !loaded.PublicKey.(interface{ Equal(crypto.PublicKey) bool }).Equal(ks.PublicKey) {
+		t.Error("JWT_PUBLIC_KEY form does not round trip")
+	}
+}
@@ -0,0 +1,101 @@
+package auth
+
+import (
+	"errors"
+	"sync"
+	"time"
+)
+
+// Revocation revokes a single token by its jti, or every token of a user.
+// It lasts until Until, or the list default TTL from now when zero.
+type Revocation struct {
+	JTI   string    `json:"jti,omitempty"`
+	UID   string    `json:"uid,omitempty"`
+	Until time.Time `json:"until,omitempty"`
+}
+
+func (r Revocation) Validate() error {
+	if r.JTI == "" && r.UID == "" {
+		return errors.New("revocation needs a jti or an uid")
+	}
+
+	return nil
+}
+
+// Matches tells whether the revocation applies to the token id or user.
+func (r Revocation) Matches(jti, uid string) bool {
+	return (r.JTI != "" && r.JTI == jti) || (r.UID != "" && r.UID == uid)
+}
+
+// RevocationList holds the revoked token ids and users.
+type RevocationList struct {
+	// Lifetime of revocations without an explicit end
+	DefaultTTL time.Duration
+
+	mutex  sync.RWMutex
+	tokens map[string]time.Time
+	users  map[string]time.Time
+}
+
+func NewRevocationList(defaultTTL time.Duration) *RevocationList {
+	return &RevocationList{
+		DefaultTTL: defaultTTL,
+		tokens:     make(map[string]time.Time),
+		users:      make(map[string]time.Time),
+	}
+}
+
+// Revoke adds the revocation to the list and drops the expired ones.
+func (l *RevocationList) Revoke(r Revocation) error {
+	if err := r.Validate(); err != nil {
+		return err
+	}
+
+	now := time.Now()
+	if r.Until.IsZero() {
+		r.Until = now.Add(l.DefaultTTL)
+	}
+
+	l.mutex.Lock()
+	defer l.mutex.Unlock()
+
+	for _, m := range []map[string]time.Time{l.tokens, l.users} {
+		for k, until := range m {
+			if now.After(until) {
+				delete(m, k)
+			}
+		}
+	}
+
+	if r.JTI != "" {
+		l.tokens[r.JTI] = r.Until
+	}
+
+	if r.UID != "" {
+		l.users[r.UID] = r.Until
+	}
+
+	return nil
+}
+
+// IsRevoked tells whether the token id or the user is currently revoked. A
+// nil list revokes nothing.
+func (l *RevocationList) IsRevoked(jti, uid string) bool {
+	if l == nil {
+		return false
+	}
+
+	l.mutex.RLock()
+	defer l.mutex.RUnlock()
+
+	now := time.Now()
+	if until, ok := l.tokens[jti]; ok && jti != "" && now.Before(until) {
+		return true
+	}
+
+	if until, ok := l.users[uid]; ok && uid != "" && now.Before(until) {
+		return true
+	}
+
+	return false
+}
@@ -1,16 +1,35 @@
 package message
 
-import "encoding/json"
+import (
+	"encoding/json"
+	"errors"
+)
 
 type Request struct {
 	Method  string
 	Streams []string
+	Token   string
+}
+
+// CodedError is an error response carrying a machine readable code.
+type CodedError struct {
+	Code    string
+	Message string
+}
+
+func (e *CodedError) Error() string {
+	return e.Message
 }
 
 func PackOutgoingResponse(err error, message interface{}) ([]byte, error) {
 	res := make(map[string]interface{}, 1)
 	if err != nil {
 		res["error"] = err.Error()
+
+		var coded *CodedError
+		if errors.As(err, &coded) {
+			res["code"] = coded.Code
+		}
 	} else {
 		res["success"] = message
 	}
@@ -22,3 +41,25 @@ func PackOutgoingEvent(channel string, data interface{}) ([]byte, error) {
 	resp[channel] = data
 	return json.Marshal(resp)
 }
+
+// PackOutgoingRawEvent frames an already encoded JSON body under the channel
+// key without decoding it, producing the same shape as PackOutgoingEvent.
+func PackOutgoingRawEvent(channel string, data []byte) ([]byte, error) {
+	if !json.Valid(data) {
+		return nil, errors.New("invalid JSON body for " + channel)
+	}
+
+	key, err := json.Marshal(channel)
+	if err != nil {
+		return nil, err
+	}
+
+	ev := make([]byte, 0, len(key)+len(data)+3)
+	ev = append(ev, '{')
+	ev = append(ev, key...)
+	ev = append(ev, ':')
+	ev = append(ev, data...)
+	ev = append(ev, '}')
+
+	return ev, nil
+}
@@ -0,0 +1,92 @@
+package message
+
+import (
+	"encoding/json"
+	"reflect"
+	"testing"
+)
+
+func TestPackOutgoingRawEventMatchesPackOutgoingEvent(t *testing.T) {
+	bodies := []string{
+		`{"price":"42.5","amount":"0.1","side":"buy"}`,
+		`[["42.5","0.1"],["42.4","2"]]`,
+		`"text"`,
+		`12`,
+		`null`,
+		` {"nested": {"a": [1, 2, {"b": "<tag>&"}]}} `,
+	}
+
+	for _, body := range bodies {
+		raw, err := PackOutgoingRawEvent("btcusd.trades", []byte(body))
+		if err != nil {
+			t.Fatalf("PackOutgoingRawEvent(%s): %v", body, err)
+		}
+
+		var data interface{}
+		if err := json.Unmarshal([]byte(body), &data); err != nil {
+			t.Fatal(err)
+		}
+
+		encoded, err := PackOutgoingEvent("btcusd.trades", data)
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		var got, want interface{}
+		if err := json.Unmarshal(raw, &got); err != nil {
+			t.Fatalf("spliced event %s is not JSON: %v", raw, err)
+		}
+		if err := json.Unmarshal(encoded, &want); err != nil {
+			t.Fatal(err)
+		}
+
+		if !reflect.DeepEqual(got, want) {
+			t.Errorf("spliced event %s, want %s", raw, encoded)
+		}
+	}
+}
+
+func TestPackOutgoingRawEventEscapesChannel(t *testing.T) {
+	raw, err := PackOutgoingRawEvent(`a"b`, []byte(`1`))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if string(raw) != `{"a\"b":1}` {
+		t.Errorf("got %s", raw)
+	}
+}
+
+func TestPackOutgoingRawEventRejectsInvalidJSON(t *testing.T) {
+	for _, body := range []string{``, `{`, `{"a":}`, `not json`, `{"a":1}}`} {
+		if raw, err := PackOutgoingRawEvent("btcusd.trades", []byte(body)); err == nil {
+			t.Errorf("PackOutgoingRawEvent(%q) = %s, want an error", body, raw)
+		}
+	}
+}
+
+var benchmarkBody = []byte(`{"tid":123456,"taker_type":"buy","date":1700000000,"price":"42123.45","amount":"0.01500000"}`)
+
+func BenchmarkPackOutgoingRawEvent(b *testing.B) {
+	b.ReportAllocs()
+	for i := 0; i < b.N; i++ {
+		if _, err := PackOutgoingRawEvent("btcusd.trades", benchmarkBody); err != nil {
+			b.Fatal(err)
+		}
+	}
+}
+
+// BenchmarkPackOutgoingEvent is the decode and encode path the raw splicing
+// replaced, for comparison.
+func BenchmarkPackOutgoingEvent(b *testing.B) {
+	b.ReportAllocs()
+	for i := 0; i < b.N; i++ {
+		var data interface{}
+		if err := json.Unmarshal(benchmarkBody, &data); err != nil {
+			b.Fatal(err)
+		}
+		if _, err := PackOutgoingEvent("btcusd.trades", data); err != nil {
+			b.Fatal(err)
+		}
+	}
+}
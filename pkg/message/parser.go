@@ -51,6 +51,13 @@ func Parse(msg []byte) (Request, error) {
 				parsed.Streams = append(parsed.Streams, streams.Index(i).Interface().(string))
 			}
 		}
+	case "auth", "refresh":
+		parsed.Method = v["event"].(string)
+		token, ok := v["token"].(string)
+		if !ok || token == "" {
+			return parsed, fmt.Errorf("no token provided")
+		}
+		parsed.Token = token
 	default:
 		return parsed, errors.New("could not parse Type: Invalid event")
 	}
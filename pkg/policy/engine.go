@@ -0,0 +1,83 @@
+package policy
+
+import (
+	"log"
+	"os"
+	"sync/atomic"
+	"time"
+)
+
+// Engine evaluates subscriptions against a policy that can be swapped at
+// runtime, and reloaded from its file when it changes.
+type Engine struct {
+	policy atomic.Pointer[Policy]
+
+	// Policy file, empty for a fixed policy
+	file    string
+	modTime time.Time
+}
+
+func NewEngine(p *Policy) *Engine {
+	e := &Engine{}
+	e.policy.Store(p)
+
+	return e
+}
+
+// NewFileEngine creates an engine from a JSON policy file.
+func NewFileEngine(file string) (*Engine, error) {
+	info, err := os.Stat(file)
+	if err != nil {
+		return nil, err
+	}
+
+	p, err := LoadFile(file)
+	if err != nil {
+		return nil, err
+	}
+
+	e := NewEngine(p)
+	e.file = file
+	e.modTime = info.ModTime()
+
+	return e, nil
+}
+
+func (e *Engine) Evaluate(s Subject, stream string) Decision {
+	return e.policy.Load().Evaluate(s, stream)
+}
+
+// Set replaces the policy.
+func (e *Engine) Set(p *Policy) {
+	e.policy.Store(p)
+}
+
+// Watch reloads the policy file on the given period when it changed, keeping
+// the current policy if the new one is invalid, and calls reloaded, when not
+// nil, after each reload. It returns at once for engines without a file.
+func (e *Engine) Watch(period time.Duration, reloaded func()) {
+	if e.file == "" {
+		return
+	}
+
+	for range time.Tick(period) {
+		info, err := os.Stat(e.file)
+		if err != nil || info.ModTime().Equal(e.modTime) {
+			continue
+		}
+
+		p, err := LoadFile(e.file)
+		if err != nil {
+			log.Printf("Failed to reload policy: %v\n", err)
+			continue
+		}
+
+		e.modTime = info.ModTime()
+		e.Set(p)
+		log.Printf("Reloaded policy from %s\n", e.file)
+
+		if reloaded != nil {
+			reloaded()
+		}
+	}
+}
@@ -0,0 +1,247 @@
+package policy
+
+import (
+	"encoding/json"
+	"fmt"
+	"os"
+	"path"
+	"strings"
+)
+
+// Stream scopes, see StreamScope.
+const (
+	ScopePublic   = "public"
+	ScopePrefixed = "prefixed"
+	ScopePrivate  = "private"
+)
+
+const (
+	EffectAllow = "allow"
+	EffectDeny  = "deny"
+)
+
+// StreamScope returns the scope of a stream: private streams have no dot
+// (balance), prefixed ones two (admin.btcusd.trades) and public ones one
+// (btcusd.trades).
+func StreamScope(stream string) string {
+	switch strings.Count(stream, ".") {
+	case 0:
+		return ScopePrivate
+	case 2:
+		return ScopePrefixed
+	default:
+		return ScopePublic
+	}
+}
+
+// Subject is the identity a subscription is evaluated for.
+type Subject struct {
+	UID   string
+	Role  string
+	Level int
+}
+
+// Rule applies its effect to subjects matching all of its conditions when
+// they subscribe to a stream matching one of its patterns. Empty conditions
+// match everything.
+type Rule struct {
+	// public, prefixed or private, any scope when empty
+	Scope string `json:"scope,omitempty"`
+
+	// Stream patterns in path.Match syntax, e.g. admin.* or *.trades
+	Streams []string `json:"streams"`
+
+	Roles    []string `json:"roles,omitempty"`
+	UIDs     []string `json:"uids,omitempty"`
+	MinLevel int      `json:"min_level,omitempty"`
+
+	// allow or deny, allow when empty
+	Effect string `json:"effect,omitempty"`
+}
+
+// Policy is an ordered list of rules, the first rule applying to a
+// subscription decides it. Defaults gives the effect per scope when no rule
+// applies.
+type Policy struct {
+	Rules    []Rule            `json:"rules"`
+	Defaults map[string]string `json:"defaults,omitempty"`
+}
+
+// Decision is the outcome of a policy evaluation.
+type Decision struct {
+	Allowed bool
+	Reason  string
+}
+
+// Evaluator decides subscriptions, it is implemented by Policy and Engine.
+type Evaluator interface {
+	Evaluate(s Subject, stream string) Decision
+}
+
+// Authorize decides a subscription the way the server does: private streams
+// require privateMinLevel whatever the policy, as on /private, then the
+// policy decides.
+func Authorize(e Evaluator, s Subject, stream string, privateMinLevel int) Decision {
+	if StreamScope(stream) == ScopePrivate && s.Level < privateMinLevel {
+		return Decision{Reason: fmt.Sprintf("level %d required for private streams", privateMinLevel)}
+	}
+
+	return e.Evaluate(s, stream)
+}
+
+func contains(list []string, el string) bool {
+	for _, l := range list {
+		if l == el {
+			return true
+		}
+	}
+	return false
+}
+
+func (r Rule) matchStream(stream string) bool {
+	for _, pattern := range r.Streams {
+		if ok, _ := path.Match(pattern, stream); ok {
+			return true
+		}
+	}
+	return false
+}
+
+func (r Rule) matchSubject(s Subject) bool {
+	if len(r.Roles) > 0 && !contains(r.Roles, s.Role) {
+		return false
+	}
+
+	if len(r.UIDs) > 0 && !contains(r.UIDs, s.UID) {
+		return false
+	}
+
+	return s.Level >= r.MinLevel
+}
+
+// defaultEffects are used for scopes missing from Policy.Defaults.
+var defaultEffects = map[string]string{
+	ScopePublic:   EffectAllow,
+	ScopePrefixed: EffectDeny,
+	ScopePrivate:  EffectAllow,
+}
+
+// Evaluate decides whether the subject may subscribe to the stream.
+func (p *Policy) Evaluate(s Subject, stream string) Decision {
+	scope := StreamScope(stream)
+
+	for i, r := range p.Rules {
+		if r.Scope != "" && r.Scope != scope {
+			continue
+		}
+
+		if !r.matchStream(stream) || !r.matchSubject(s) {
+			continue
+		}
+
+		effect := r.Effect
+		if effect == "" {
+			effect = EffectAllow
+		}
+
+		return Decision{
+			Allowed: effect == EffectAllow,
+			Reason:  fmt.Sprintf("rule %d: %s", i, effect),
+		}
+	}
+
+	effect, ok := p.Defaults[scope]
+	if !ok {
+		effect = defaultEffects[scope]
+	}
+
+	return Decision{
+		Allowed: effect == EffectAllow,
+		Reason:  fmt.Sprintf("default for %s streams: %s", scope, effect),
+	}
+}
+
+// Validate reports rules that cannot be evaluated as written.
+func (p *Policy) Validate() error {
+	for i, r := range p.Rules {
+		switch r.Scope {
+		case "", ScopePublic, ScopePrefixed, ScopePrivate:
+		default:
+			return fmt.Errorf("rule %d: unknown scope %q", i, r.Scope)
+		}
+
+		switch r.Effect {
+		case "", EffectAllow, EffectDeny:
+		default:
+			return fmt.Errorf("rule %d: unknown effect %q", i, r.Effect)
+		}
+
+		if len(r.Streams) == 0 {
+			return fmt.Errorf("rule %d: no streams", i)
+		}
+
+		for _, pattern := range r.Streams {
+			if _, err := path.Match(pattern, ""); err != nil {
+				return fmt.Errorf("rule %d: invalid pattern %q", i, pattern)
+			}
+		}
+	}
+
+	for scope, effect := range p.Defaults {
+		if _, ok := defaultEffects[scope]; !ok {
+			return fmt.Errorf("defaults: unknown scope %q", scope)
+		}
+
+		if effect != EffectAllow && effect != EffectDeny {
+			return fmt.Errorf("defaults: unknown effect %q", effect)
+		}
+	}
+
+	return nil
+}
+
+// LoadFile reads a JSON policy file.
+func LoadFile(file string) (*Policy, error) {
+	data, err := os.ReadFile(file)
+	if err != nil {
+		return nil, err
+	}
+
+	p := &Policy{}
+	if err := json.Unmarshal(data, p); err != nil {
+		return nil, fmt.Errorf("parse policy: %w", err)
+	}
+
+	if err := p.Validate(); err != nil {
+		return nil, fmt.Errorf("invalid policy: %w", err)
+	}
+
+	return p, nil
+}
+
+// FromRBAC builds the policy equivalent to the RANGO_RBAC_* role lists:
+// system.* streams for the system roles, other prefixed streams for the
+// admin roles, and private streams from the given level.
+func FromRBAC(adminRoles, systemRoles []string, privateMinLevel int) *Policy {
+	rules := []Rule{}
+
+	// An empty role list would match every role
+	if len(systemRoles) > 0 {
+		rules = append(rules, Rule{Scope: ScopePrefixed, Streams: []string{"system.*"}, Roles: systemRoles})
+	}
+	rules = append(rules, Rule{Scope: ScopePrefixed, Streams: []string{"system.*"}, Effect: EffectDeny})
+
+	if len(adminRoles) > 0 {
+		rules = append(rules, Rule{Scope: ScopePrefixed, Streams: []string{"*"}, Roles: adminRoles})
+	}
+	rules = append(rules, Rule{Scope: ScopePrivate, Streams: []string{"*"}, MinLevel: privateMinLevel})
+
+	return &Policy{
+		Rules: rules,
+		Defaults: map[string]string{
+			ScopePublic:   EffectAllow,
+			ScopePrefixed: EffectDeny,
+			ScopePrivate:  EffectDeny,
+		},
+	}
+}
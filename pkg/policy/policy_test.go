@@ -0,0 +1,34 @@
+package policy
+
+import "testing"
+
+func TestAuthorizeRequiresPrivateMinLevel(t *testing.T) {
+	p := &Policy{
+		Defaults: map[string]string{ScopePrivate: EffectAllow},
+	}
+
+	if d := Authorize(p, Subject{UID: "UID1", Level: 2}, "balances", 3); d.Allowed {
+		t.Error("private stream allowed below the minimum level")
+	}
+
+	if d := Authorize(p, Subject{UID: "UID1", Level: 3}, "balances", 3); !d.Allowed {
+		t.Errorf("private stream denied at the minimum level: %s", d.Reason)
+	}
+
+	// Only private streams require the level
+	if d := Authorize(p, Subject{Level: 0}, "btcusd.trades", 3); !d.Allowed {
+		t.Errorf("public stream denied: %s", d.Reason)
+	}
+}
+
+func TestAuthorizeEngine(t *testing.T) {
+	e := NewEngine(FromRBAC([]string{"admin"}, nil, 0))
+
+	if d := Authorize(e, Subject{Role: "admin", Level: 1}, "admin.btcusd.orders", 3); !d.Allowed {
+		t.Errorf("admin stream denied to an admin: %s", d.Reason)
+	}
+
+	if d := Authorize(e, Subject{Role: "admin", Level: 1}, "balances", 3); d.Allowed {
+		t.Error("private stream allowed below the minimum level")
+	}
+}
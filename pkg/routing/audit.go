@@ -0,0 +1,50 @@
+package routing
+
+import (
+	"fmt"
+	"time"
+
+	"github.com/shinhagunn/websocket/config"
+	"github.com/shinhagunn/websocket/pkg/audit"
+	"github.com/shinhagunn/websocket/pkg/policy"
+)
+
+// Audit sinks, see RANGO_AUDIT_SINK.
+const (
+	AuditFile  = "file"
+	AuditKafka = "kafka"
+)
+
+func newAuditLogger(conf config.Rango) (audit.Logger, error) {
+	switch conf.AuditSink {
+	case "":
+		return audit.Nop{}, nil
+	case AuditFile:
+		return audit.NewFileLogger(conf.AuditFile, int64(conf.AuditMaxSize)<<20, conf.AuditMaxBackups)
+	case AuditKafka:
+		return audit.NewKafkaLogger(conf.AuditKafkaBrokers, conf.AuditKafkaTopic)
+	default:
+		return nil, fmt.Errorf("invalid audit sink %q", conf.AuditSink)
+	}
+}
+
+// auditSubscription records a subscription decision of the client.
+func (e *Epoll) auditSubscription(client *Client, stream string, decision policy.Decision) {
+	auth := client.GetAuth()
+
+	record := audit.Record{
+		Time:     time.Now(),
+		Event:    audit.EventSubscribe,
+		UID:      auth.UID,
+		Role:     auth.Role,
+		IP:       client.IP,
+		Stream:   stream,
+		Decision: audit.DecisionDeny,
+		Reason:   decision.Reason,
+	}
+	if decision.Allowed {
+		record.Decision = audit.DecisionAllow
+	}
+
+	e.Audit.Log(record)
+}
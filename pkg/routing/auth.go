@@ -0,0 +1,127 @@
+package routing
+
+import (
+	"errors"
+	"log"
+	"time"
+)
+
+// TokenValidator checks a JWT sent over the socket and returns the identity it
+// carries. Its error is reported to the client as the rejection reason.
+type TokenValidator func(token string) (Auth, error)
+
+// handleAuth upgrades an anonymous client with the "auth" method, or swaps the
+// token of an authenticated one with "refresh". The user cannot change over
+// the life of a connection, only its role.
+func (e *Epoll) handleAuth(req *Request) {
+	if e.ValidateToken == nil {
+		e.send(NewSendMessager(req.client, []byte(responseMust(errors.New("authentication is not available"), nil))))
+		return
+	}
+
+	auth, err := e.ValidateToken(req.Token)
+	if err != nil {
+		log.Printf("Invalid token on %s: %v\n", req.Method, err)
+		e.send(NewSendMessager(req.client, []byte(responseMust(err, nil))))
+		return
+	}
+
+	req.client.ops.Lock()
+	defer req.client.ops.Unlock()
+
+	current := req.client.GetAuth()
+	switch {
+	case req.Method == "refresh" && current.UID == "":
+		err = errors.New("not authenticated")
+	case current.UID != "" && current.UID != auth.UID:
+		err = errors.New("token belongs to another user")
+	}
+
+	if err != nil {
+		e.send(NewSendMessager(req.client, []byte(responseMust(err, nil))))
+		return
+	}
+
+	evicted, err := e.readmit(req.client, auth)
+	if err != nil {
+		e.send(NewSendMessager(req.client, []byte(responseMust(err, nil))))
+		return
+	}
+	if len(evicted) > 0 {
+		// Closing takes the ops lock of the evicted clients
+		go e.evict(evicted)
+	}
+
+	req.client.setAuth(auth)
+	e.revalidateSubscriptions(req.client, current)
+
+	message := "authenticated"
+	if req.Method == "refresh" {
+		message = "refreshed"
+	}
+
+	e.send(NewSendMessager(req.client, []byte(responseMust(nil, map[string]interface{}{
+		"message": message,
+		"uid":     auth.UID,
+		"role":    auth.Role,
+		"streams": req.client.GetSubscriptions(),
+	}))))
+}
+
+// WatchPolicy reloads the policy file when it changes and drops the
+// subscriptions the new policy no longer allows, it never returns for
+// policies loaded from a file.
+func (e *Epoll) WatchPolicy(period time.Duration) {
+	e.Policy.Watch(period, e.revalidateClients)
+}
+
+// revalidateClients drops the subscriptions of every client the policy no
+// longer allows, and tells them which streams they lost.
+func (e *Epoll) revalidateClients() {
+	for _, client := range e.Clients() {
+		client.ops.Lock()
+		before := client.GetSubscriptions()
+		e.revalidateSubscriptions(client, client.GetAuth())
+		after := client.GetSubscriptions()
+		client.ops.Unlock()
+
+		lost := []string{}
+		for _, s := range before {
+			if !contains(after, s) {
+				lost = append(lost, s)
+			}
+		}
+
+		if len(lost) > 0 {
+			log.Printf("Policy reload dropped %v of %q\n", lost, client.GetAuth().UID)
+			e.send(NewSendMessager(client, eventMust("subscriptions.revoked", map[string]interface{}{
+				"streams": lost,
+			})))
+		}
+	}
+}
+
+// revalidateSubscriptions drops the subscriptions the client lost access to
+// after its identity changed from previous. The caller holds the client ops
+// lock.
+func (e *Epoll) revalidateSubscriptions(client *Client, previous Auth) {
+	auth := client.GetAuth()
+
+	for _, t := range client.GetPrivateSubscriptions() {
+		if auth.UID != previous.UID || !e.authorize(t, auth).Allowed {
+			e.dropPrivate(client, previous.UID, t)
+		}
+	}
+
+	for _, t := range client.GetSubscriptions() {
+		if isPrivateStream(t) || e.authorize(t, auth).Allowed {
+			continue
+		}
+
+		if isPrefixedStream(t) {
+			e.dropPrefixed(client, t)
+		} else {
+			e.dropPublic(client, t)
+		}
+	}
+}
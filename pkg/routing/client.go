@@ -2,28 +2,89 @@ package routing
 
 import (
 	"log"
+	"sync"
+	"time"
 
 	"github.com/gorilla/websocket"
 )
 
 type Auth struct {
-	UID  string
-	Role string
+	UID   string
+	Role  string
+	Level int
+
+	// Expiry of the token the identity comes from, zero if it never expires
+	ExpiresAt time.Time
+
+	// Id (jti) of the token the identity comes from
+	TokenID string
+}
+
+// Conn is the transport a Client reads requests from and writes messages to.
+// It is satisfied by *websocket.Conn and by QueueConn for plain HTTP transports.
+type Conn interface {
+	ReadMessage() (messageType int, p []byte, err error)
+	WriteMessage(messageType int, data []byte) error
+	SetWriteDeadline(t time.Time) error
+	Close() error
+}
+
+// preparedWriter is implemented by transports able to write a websocket
+// message framed ahead of time.
+type preparedWriter interface {
+	WritePreparedMessage(pm *websocket.PreparedMessage) error
+}
+
+// eventWriter is implemented by transports that need the stream and sequence
+// number of each topic event, e.g. to build resumable event ids.
+type eventWriter interface {
+	WriteEvent(stream string, seq uint64, data []byte) error
 }
 
 type Client struct {
+	// Serializes the subscription and identity changes of the client
+	ops sync.Mutex
+
+	// Whether the client was removed, guarded by ops. Removed clients are
+	// never subscribed again.
+	closed bool
+
+	// Guards Auth, the subscriptions and expiryWarned
+	mutex sync.RWMutex
+
 	Auth Auth
 
+	// Remote IP address of the peer
+	IP string
+
 	pubSub  []string
 	privSub []string
 
-	conn *websocket.Conn
+	// Whether the client was warned its token is about to expire
+	expiryWarned bool
+
+	// Rates of inbound messages, nil for clients not read through epoll
+	rates *rateLimits
+
+	// Outbound messages waiting to be written
+	queue outQueue
+
+	// Socket polled by epoll, -1 for clients not read through epoll
+	fd int
+
+	// Identity the connection limits count the client under, guarded by the
+	// Epoll mutex
+	limitUID string
+	limitIP  string
+
+	conn Conn
 }
 
 // NewClient handles websocket requests from the peer.
-func NewClient(conn *websocket.Conn, auth Auth) *Client {
+func NewClient(conn Conn, auth Auth) *Client {
 	client := &Client{
 		conn:    conn,
+		fd:      -1,
 		Auth:    auth,
 		pubSub:  []string{},
 		privSub: []string{},
@@ -46,6 +107,23 @@ func (c *Client) WriteMessage(messageType int, data []byte) error {
 	return c.conn.WriteMessage(messageType, data)
 }
 
+// write delivers a queued message using the richest form the transport
+// supports: topic events with their sequence, then prepared frames, then
+// plain text messages.
+func (c *Client) write(mess SendMessager) error {
+	c.conn.SetWriteDeadline(time.Now().Add(writeWait))
+
+	if w, ok := c.conn.(eventWriter); ok && mess.stream != "" {
+		return w.WriteEvent(mess.stream, mess.seq, mess.msg)
+	}
+
+	if w, ok := c.conn.(preparedWriter); ok && mess.prepared != nil {
+		return w.WritePreparedMessage(mess.prepared)
+	}
+
+	return c.conn.WriteMessage(websocket.TextMessage, mess.msg)
+}
+
 func (c *Client) Close() {
 	c.conn.Close()
 }
@@ -55,45 +133,63 @@ func (c *Client) Close() {
 // }
 
 func (c *Client) GetAuth() Auth {
+	c.mutex.RLock()
+	defer c.mutex.RUnlock()
+
 	return c.Auth
 }
 
+// setAuth replaces the identity of the client.
+func (c *Client) setAuth(auth Auth) {
+	c.mutex.Lock()
+	defer c.mutex.Unlock()
+
+	c.Auth = auth
+	c.expiryWarned = false
+}
+
 func (c *Client) GetSubscriptions() []string {
-	return append(c.pubSub, c.privSub...)
+	c.mutex.RLock()
+	defer c.mutex.RUnlock()
+
+	return append(append([]string{}, c.pubSub...), c.privSub...)
+}
+
+func (c *Client) GetPrivateSubscriptions() []string {
+	c.mutex.RLock()
+	defer c.mutex.RUnlock()
+
+	return append([]string{}, c.privSub...)
 }
 
 func (c *Client) SubscribePublic(s string) {
+	c.mutex.Lock()
+	defer c.mutex.Unlock()
+
 	if !contains(c.pubSub, s) {
 		c.pubSub = append(c.pubSub, s)
 	}
 }
 
 func (c *Client) SubscribePrivate(s string) {
+	c.mutex.Lock()
+	defer c.mutex.Unlock()
+
 	if !contains(c.privSub, s) {
 		c.privSub = append(c.privSub, s)
 	}
 }
 
 func (c *Client) UnsubscribePublic(s string) {
-	l := make([]string, len(c.pubSub)-1)
-	i := 0
-	for _, el := range c.pubSub {
-		if s != el {
-			l[i] = el
-			i++
-		}
-	}
-	c.pubSub = l
+	c.mutex.Lock()
+	defer c.mutex.Unlock()
+
+	c.pubSub = remove(c.pubSub, s)
 }
 
 func (c *Client) UnsubscribePrivate(s string) {
-	l := make([]string, len(c.privSub)-1)
-	i := 0
-	for _, el := range c.privSub {
-		if s != el {
-			l[i] = el
-			i++
-		}
-	}
-	c.privSub = l
+	c.mutex.Lock()
+	defer c.mutex.Unlock()
+
+	c.privSub = remove(c.privSub, s)
 }
@@ -3,6 +3,7 @@ package routing
 import (
 	"bytes"
 	"errors"
+	"runtime"
 	"strings"
 	"sync"
 	"syscall"
@@ -12,7 +13,10 @@ import (
 
 	"github.com/gorilla/websocket"
 	"github.com/shinhagunn/websocket/config"
+	"github.com/shinhagunn/websocket/pkg/audit"
+	"github.com/shinhagunn/websocket/pkg/auth"
 	msgPkg "github.com/shinhagunn/websocket/pkg/message"
+	"github.com/shinhagunn/websocket/pkg/policy"
 	"github.com/twmb/franz-go/pkg/kgo"
 
 	"golang.org/x/sys/unix"
@@ -22,18 +26,25 @@ const (
 	// Time allowed to write a message to the peer.
 	writeWait = 10 * time.Second
 
-	// Maximum cap messages in channel
-	maxBufferedMessages = 256
+	// Interval between two removals of the topics without clients.
+	topicReapPeriod = 10 * time.Second
 )
 
 type Request struct {
 	client *Client
 	msgPkg.Request
+
+	// last sequence seen per stream, used to replay buffered events on resume
+	lastSeq map[string]uint64
 }
 
 type SendMessager struct {
-	client *Client
-	msg    []byte
+	client   *Client
+	msg      []byte
+	prepared *websocket.PreparedMessage
+
+	stream string // subscribed stream of a topic event
+	seq    uint64 // sequence number of the event within its topic
 }
 
 type Event struct {
@@ -42,6 +53,9 @@ type Event struct {
 	Type   string // event type
 	Topic  string // topic routing key (stream.type)
 	Body   []byte // event json body
+
+	payload  []byte                     // body framed under the topic key, built once
+	prepared *websocket.PreparedMessage // payload framed as a websocket message, built once
 }
 
 func NewSendMessager(client *Client, msg []byte) SendMessager {
@@ -51,94 +65,332 @@ func NewSendMessager(client *Client, msg []byte) SendMessager {
 	}
 }
 
+func newEventSendMessager(client *Client, entry historyEntry, stream string) SendMessager {
+	return SendMessager{
+		client:   client,
+		msg:      entry.msg,
+		prepared: entry.prepared,
+		stream:   stream,
+		seq:      entry.seq,
+	}
+}
+
 // Epoll manage handler clients
 type Epoll struct {
-	// Epoll file descriptor
-	fd int
+	// Epoll file descriptors, one per read loop
+	fds []int
 
 	// The websocket connections
 	Connections map[int]*Client
 
-	// Buffered channel of outbound messages.
-	send chan SendMessager
+	// Clients not read through epoll, such as server-sent events streams and
+	// long-polling sessions
+	attached map[*Client]struct{}
+
+	// Clients with queued outbound messages, served by the Write workers
+	ready *readyList
 
-	// List of clients registered to public topics
-	PublicTopics map[string]*Topic
+	// Outbound messages by outcome
+	Queues QueueStats
 
-	// List of clients registered to private topics
-	PrivateTopics map[string]map[string]*Topic
+	// Topics of public streams, by stream
+	public *topicRegistry
 
-	// map[prefix -> map[topic -> *Topic]]
-	PrefixedTopics map[string]map[string]*Topic
+	// Topics of prefixed streams, by prefixed stream
+	prefixed *topicRegistry
+
+	// Topics of private streams, by uid/stream
+	private *topicRegistry
 
-	// map[prefix -> allowed roles]
 	Config *config.Config
 
+	// Validates tokens sent with the auth and refresh methods, nil disables them
+	ValidateToken TokenValidator
+
+	// Revoked tokens and users, connections are closed when they get revoked
+	Revocations *auth.RevocationList
+
+	// Decides which streams a client may subscribe to
+	Policy *policy.Engine
+
+	// Records privileged subscription decisions
+	Audit audit.Logger
+
+	// Open connections per user and IP, capped by the config
+	limits *connLimits
+
+	// Requests over the client rates
+	RateLimits RateLimitStats
+
+	// Guards the connections and their limits, topics have their own locks
 	mutex *sync.RWMutex
 }
 
 func NewEpoll(config *config.Config) (*Epoll, error) {
-	fd, err := unix.EpollCreate1(0)
+	engine := policy.NewEngine(policy.FromRBAC(config.Rango.RbacAdmin, config.Rango.RbacSystem, config.Rango.PrivateMinLevel))
+	if config.Rango.PolicyFile != "" {
+		var err error
+		if engine, err = policy.NewFileEngine(config.Rango.PolicyFile); err != nil {
+			return nil, err
+		}
+	}
+
+	limits, err := newConnLimits(config.Rango)
 	if err != nil {
 		return nil, err
 	}
 
-	return &Epoll{
-		fd:             fd,
-		Connections:    make(map[int]*Client),
-		send:           make(chan SendMessager, maxBufferedMessages),
-		PublicTopics:   make(map[string]*Topic),
-		PrivateTopics:  make(map[string]map[string]*Topic),
-		PrefixedTopics: make(map[string]map[string]*Topic),
-		Config:         config,
-		mutex:          &sync.RWMutex{},
-	}, nil
+	if err := validateRateLimitAction(config.Rango); err != nil {
+		return nil, err
+	}
+
+	if err := validateOverflowPolicy(config.Rango); err != nil {
+		return nil, err
+	}
+
+	auditLogger, err := newAuditLogger(config.Rango)
+	if err != nil {
+		return nil, err
+	}
+
+	loops := config.Rango.EpollLoops
+	if loops <= 0 {
+		loops = runtime.GOMAXPROCS(0)
+	}
+
+	fds := make([]int, loops)
+	for i := range fds {
+		if fds[i], err = unix.EpollCreate1(0); err != nil {
+			for _, fd := range fds[:i] {
+				unix.Close(fd)
+			}
+			return nil, err
+		}
+	}
+
+	e := &Epoll{
+		fds:         fds,
+		Connections: make(map[int]*Client),
+		attached:    make(map[*Client]struct{}),
+		ready:       newReadyList(),
+		Config:      config,
+		Policy:      engine,
+		Audit:       auditLogger,
+		limits:      limits,
+		mutex:       &sync.RWMutex{},
+	}
+	e.public = newTopicRegistry(e.send, config.Rango.TopicRetention)
+	e.prefixed = newTopicRegistry(e.send, config.Rango.TopicRetention)
+	e.private = newTopicRegistry(e.send, config.Rango.TopicRetention)
+
+	return e, nil
 }
 
-func (e *Epoll) Add(client *Client) error {
-	fd := websocketFD(client.conn)
+// pollFD returns the epoll instance the connection fd is assigned to.
+func (e *Epoll) pollFD(fd int) int {
+	return e.fds[fd%len(e.fds)]
+}
 
-	err := unix.EpollCtl(e.fd, syscall.EPOLL_CTL_ADD, fd, &unix.EpollEvent{Events: unix.POLLIN | unix.POLLHUP, Fd: int32(fd)})
+// Add registers the client with epoll. Connections over the configured
+// limits fail with ErrConnectionLimit, or evict the oldest connections of the
+// same user or IP, depending on the limit action.
+func (e *Epoll) Add(client *Client) error {
+	conn, ok := client.conn.(*websocket.Conn)
+	if !ok {
+		return errors.New("only websocket connections can be polled")
+	}
+	fd, err := websocketFD(conn)
 	if err != nil {
 		return err
 	}
 
+	client.rates = e.newRateLimits()
+
 	e.mutex.Lock()
-	defer e.mutex.Unlock()
+	evicted, err := e.limits.admit(client, client.GetAuth())
+	if err != nil {
+		e.mutex.Unlock()
+		return err
+	}
+
+	err = unix.EpollCtl(e.pollFD(fd), syscall.EPOLL_CTL_ADD, fd, &unix.EpollEvent{Events: unix.POLLIN | unix.POLLHUP, Fd: int32(fd)})
+	if err != nil {
+		e.limits.release(client)
+		e.mutex.Unlock()
+		return err
+	}
 
+	client.fd = fd
 	e.Connections[fd] = client
 	if len(e.Connections)%100 == 0 {
 		log.Printf("Total number of connections: %v\n", len(e.Connections))
 	}
+	e.mutex.Unlock()
+
+	e.evict(evicted)
 
 	return nil
 }
 
-func (e *Epoll) Remove(client *Client) error {
-	fd := websocketFD(client.conn)
+// Attach registers a client that is not read through epoll, so it is
+// watched for token expiry and revocation and subject to the connection
+// and rate limits like websockets. It must be removed with Remove.
+func (e *Epoll) Attach(client *Client) error {
+	client.rates = e.newRateLimits()
 
-	err := unix.EpollCtl(e.fd, syscall.EPOLL_CTL_DEL, fd, nil)
+	e.mutex.Lock()
+	evicted, err := e.limits.admit(client, client.GetAuth())
 	if err != nil {
+		e.mutex.Unlock()
 		return err
 	}
 
-	e.mutex.Lock()
-	defer e.mutex.Unlock()
+	e.attached[client] = struct{}{}
+	e.mutex.Unlock()
 
-	delete(e.Connections, fd)
-	if len(e.Connections)%100 == 0 {
-		log.Printf("Total number of connections: %v\n", len(e.Connections))
+	e.evict(evicted)
+
+	return nil
+}
+
+// registered tells whether the client was added or attached and not removed
+// since. The caller holds the mutex.
+func (e *Epoll) registered(client *Client) bool {
+	if client.fd >= 0 {
+		return e.Connections[client.fd] == client
 	}
 
+	_, ok := e.attached[client]
+	return ok
+}
+
+// evict closes the connections making room for a new one.
+func (e *Epoll) evict(clients []*Client) {
+	for _, c := range clients {
+		e.closeClient(c, websocket.CloseTryAgainLater, "too many connections")
+	}
+}
+
+// Remove unsubscribes the client from every topic and closes it. Websocket
+// clients are also deregistered from epoll.
+func (e *Epoll) Remove(client *Client) error {
+	if fd := client.fd; fd >= 0 {
+		e.mutex.Lock()
+		// The fd may already be reused by another connection if the client
+		// was removed before
+		if e.Connections[fd] == client {
+			if err := unix.EpollCtl(e.pollFD(fd), syscall.EPOLL_CTL_DEL, fd, nil); err != nil {
+				e.mutex.Unlock()
+				return err
+			}
+
+			e.limits.release(client)
+			delete(e.Connections, fd)
+			if len(e.Connections)%100 == 0 {
+				log.Printf("Total number of connections: %v\n", len(e.Connections))
+			}
+		}
+		e.mutex.Unlock()
+	} else {
+		e.mutex.Lock()
+		if _, ok := e.attached[client]; ok {
+			e.limits.release(client)
+			delete(e.attached, client)
+		}
+		e.mutex.Unlock()
+	}
+
+	client.ops.Lock()
+	client.closed = true
+	client.ops.Unlock()
+
 	e.unsubscribeAll(client)
+	client.queue.close()
 	client.Close()
 
 	return nil
 }
 
-func (e *Epoll) Wait() ([]*Client, error) {
-	events := make([]unix.EpollEvent, 100)
-	n, err := unix.EpollWait(e.fd, events, 100)
+// Clients returns the websocket clients and the attached ones.
+func (e *Epoll) Clients() []*Client {
+	e.mutex.RLock()
+	defer e.mutex.RUnlock()
+
+	clients := make([]*Client, 0, len(e.Connections)+len(e.attached))
+	for _, client := range e.Connections {
+		clients = append(clients, client)
+	}
+	for client := range e.attached {
+		clients = append(clients, client)
+	}
+
+	return clients
+}
+
+// ReapTopics removes the topics left without clients for longer than
+// RANGO_TOPIC_RETENTION, it never returns.
+func (e *Epoll) ReapTopics() {
+	retention := e.Config.Rango.TopicRetention
+	if retention <= 0 {
+		return
+	}
+
+	for now := range time.Tick(topicReapPeriod) {
+		e.public.reap(now)
+		e.prefixed.reap(now)
+		e.private.reap(now)
+	}
+}
+
+// Topics returns the number of public, prefixed and private topics.
+func (e *Epoll) Topics() (int, int, int) {
+	return e.public.len(), e.prefixed.len(), e.private.len()
+}
+
+// Subscribe subscribes a client that is not read through epoll, such as a
+// server-sent events stream. Buffered events newer than lastSeq are replayed
+// for streams present in it. Attached clients are rate limited like
+// websockets, Subscribe returns false when the request went over a rate or
+// the client was removed.
+func (e *Epoll) Subscribe(client *Client, streams []string, lastSeq map[string]uint64) bool {
+	req := &Request{
+		client: client,
+		Request: msgPkg.Request{
+			Method:  "subscribe",
+			Streams: streams,
+		},
+		lastSeq: lastSeq,
+	}
+
+	if !e.allow(req) {
+		return false
+	}
+
+	return e.handleSubscribe(req)
+}
+
+// Unsubscribe is the counterpart of Subscribe for clients not read through epoll.
+func (e *Epoll) Unsubscribe(client *Client, streams []string) bool {
+	req := &Request{
+		client: client,
+		Request: msgPkg.Request{
+			Method:  "unsubscribe",
+			Streams: streams,
+		},
+	}
+
+	if !e.allow(req) {
+		return false
+	}
+	e.handleUnsubscribe(req)
+
+	return true
+}
+
+// wait blocks until sockets of the epoll instance are readable and returns
+// their clients.
+func (e *Epoll) wait(epfd int, events []unix.EpollEvent) ([]*Client, error) {
+	n, err := unix.EpollWait(epfd, events, len(events))
 	if err != nil {
 		return nil, err
 	}
@@ -171,46 +423,20 @@ func (e *Epoll) ReceiveMsg(msg *kgo.Record) {
 
 func (e *Epoll) routeMessage(msg *Event) {
 	log.Printf("Routing message %v\n", msg)
-	e.mutex.Lock()
-	defer e.mutex.Unlock()
 
 	switch msg.Scope {
 	case "public", "global":
-		topic, ok := e.PublicTopics[msg.Topic]
-		if ok {
-			topic.broadcast(msg)
-		}
-
-		if !ok {
+		if !e.public.broadcast(msg.Topic, msg) {
 			log.Printf("No public registration to %s\n", msg.Topic)
-			log.Printf("Public topics: %v\n", e.PublicTopics)
 		}
 	case "private":
-		uid := msg.Stream
-		uTopic, ok := e.PrivateTopics[uid]
-		if ok {
-			topic, ok := uTopic[msg.Topic]
-			if ok {
-				topic.broadcast(msg)
-				break
-			}
+		if !e.private.broadcast(privateKey(msg.Stream, msg.Topic), msg) {
+			log.Printf("No private registration to %s\n", msg.Topic)
 		}
-		log.Printf("No private registration to %s\n", msg.Topic)
-		log.Printf("Private topics: %v\n", e.PrivateTopics)
 	default:
-		scope, ok := e.PrefixedTopics[msg.Scope]
-		if !ok {
-			return
-		}
-
-		topic, ok := scope[msg.Topic]
-		if !ok {
-			return
+		if e.prefixed.broadcast(msg.Scope+"."+msg.Topic, msg) {
+			log.Printf("Broadcasted message scope %s\n", msg.Scope)
 		}
-
-		topic.broadcast(msg)
-
-		log.Printf("Broadcasted message scope %s\n", msg.Scope)
 	}
 }
 
@@ -220,14 +446,27 @@ func (e *Epoll) handleRequest(req *Request) {
 		e.handleSubscribe(req)
 	case "unsubscribe":
 		e.handleUnsubscribe(req)
+	case "auth", "refresh":
+		e.handleAuth(req)
 	default:
-		e.send <- NewSendMessager(req.client, []byte(responseMust(errors.New("unsupported method"), nil)))
+		e.send(NewSendMessager(req.client, []byte(responseMust(errors.New("unsupported method"), nil))))
 	}
 }
 
+// Read runs one read loop per epoll instance and blocks forever. Each loop
+// reads the requests of its own connections, so a slow request only delays
+// the connections sharing its loop.
 func (e *Epoll) Read() {
+	for _, epfd := range e.fds[1:] {
+		go e.readLoop(epfd)
+	}
+	e.readLoop(e.fds[0])
+}
+
+func (e *Epoll) readLoop(epfd int) {
+	events := make([]unix.EpollEvent, 100)
 	for {
-		connections, err := e.Wait()
+		connections, err := e.wait(epfd, events)
 		if err != nil {
 			log.Printf("Failed to epoll wait %v\n", err)
 			continue
@@ -256,47 +495,43 @@ func (e *Epoll) Read() {
 			}
 			log.Printf("Received message %s\n", mess)
 
-			if string(mess) == "ping" {
-				e.send <- NewSendMessager(client, []byte("pong"))
-				continue
-			}
-
-			req, err := msgPkg.ParseRequest(mess)
-			if err != nil {
-				e.send <- NewSendMessager(client, []byte(responseMust(err, nil)))
-				continue
-			}
-
-			e.handleRequest(&Request{
-				client:  client,
-				Request: req,
-			})
+			e.handleMessage(client, mess)
 		}
 	}
 }
 
-func (e *Epoll) Write() {
-	for mess := range e.send {
-		mess.client.conn.SetWriteDeadline(time.Now().Add(writeWait))
+// handleMessage answers a message read from the socket of the client. Every
+// message is charged to the client rate before it is answered, pings and
+// malformed requests included. It returns false when the message was dropped
+// by the rate limit.
+func (e *Epoll) handleMessage(client *Client, mess []byte) bool {
+	retry := func() bool {
+		return e.handleMessage(client, mess)
+	}
 
-		w, err := mess.client.conn.NextWriter(websocket.TextMessage)
-		if err != nil {
-			log.Println("vao day 2")
-			if err := e.Remove(mess.client); err != nil {
-				log.Printf("Failed to remove %v\n", err)
-			}
-			mess.client.Close()
-			continue
-		}
+	if !e.allowMessage(client, retry) {
+		return false
+	}
 
-		w.Write(mess.msg)
-		if err := w.Close(); err != nil {
-			log.Println("vao day 3")
-			if err := e.Remove(mess.client); err != nil {
-				log.Printf("Failed to remove %v\n", err)
-			}
-			mess.client.Close()
-			continue
-		}
+	if string(mess) == "ping" {
+		e.send(NewSendMessager(client, []byte("pong")))
+		return true
+	}
+
+	req, err := msgPkg.ParseRequest(mess)
+	if err != nil {
+		e.send(NewSendMessager(client, []byte(responseMust(err, nil))))
+		return true
 	}
+
+	request := &Request{
+		client:  client,
+		Request: req,
+	}
+	if !e.allowSubscriptions(request, retry) {
+		return false
+	}
+	e.handleRequest(request)
+
+	return true
 }
@@ -0,0 +1,52 @@
+package routing
+
+import (
+	"testing"
+
+	"github.com/shinhagunn/websocket/config"
+)
+
+// newTestEpoll returns an Epoll with the default config, changed by configure
+// when not nil.
+func newTestEpoll(t *testing.T, configure func(*config.Rango)) *Epoll {
+	t.Helper()
+
+	conf, err := config.NewConfig()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if configure != nil {
+		configure(&conf.Rango)
+	}
+
+	e, err := NewEpoll(conf)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	return e
+}
+
+// newAttachedClient returns a client of a plain HTTP transport, attached to e.
+func newAttachedClient(t *testing.T, e *Epoll, auth Auth, ip string) (*Client, *QueueConn) {
+	t.Helper()
+
+	conn := NewQueueConn(16)
+	client := NewClient(conn, auth)
+	client.IP = ip
+	if err := e.Attach(client); err != nil {
+		t.Fatal(err)
+	}
+
+	return client, conn
+}
+
+func closed(conn *QueueConn) bool {
+	select {
+	case <-conn.Done():
+		return true
+	default:
+		return false
+	}
+}
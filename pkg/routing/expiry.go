@@ -0,0 +1,94 @@
+package routing
+
+import (
+	"log"
+	"time"
+
+	"github.com/gorilla/websocket"
+)
+
+// Interval between two checks of the connections token expiry.
+const expiryCheckPeriod = time.Second
+
+// WatchExpiry warns clients shortly before their token expires and, once it
+// lapsed without a refresh, closes the connection or downgrades it to
+// anonymous depending on the configured action.
+func (e *Epoll) WatchExpiry() {
+	for now := range time.Tick(expiryCheckPeriod) {
+		warned := []*Client{}
+		expired := []*Client{}
+
+		for _, client := range e.Clients() {
+			switch client.checkExpiry(now, e.Config.Rango.TokenExpiryWarning) {
+			case expiryLapsed:
+				expired = append(expired, client)
+			case expiryWarn:
+				warned = append(warned, client)
+			}
+		}
+
+		for _, client := range warned {
+			e.send(NewSendMessager(client, eventMust("auth.expiring", map[string]interface{}{
+				"expires_at": client.GetAuth().ExpiresAt.Unix(),
+			})))
+		}
+
+		for _, client := range expired {
+			e.expire(client)
+		}
+	}
+}
+
+type expiryState int
+
+const (
+	expiryValid expiryState = iota
+	expiryWarn
+	expiryLapsed
+)
+
+// checkExpiry tells whether the token of the client lapsed, or is about to
+// and the client was not warned yet, in which case it is now marked warned.
+func (c *Client) checkExpiry(now time.Time, warning time.Duration) expiryState {
+	c.mutex.Lock()
+	defer c.mutex.Unlock()
+
+	exp := c.Auth.ExpiresAt
+	if c.Auth.UID == "" || exp.IsZero() {
+		return expiryValid
+	}
+
+	switch {
+	case !now.Before(exp):
+		return expiryLapsed
+	case !c.expiryWarned && exp.Sub(now) <= warning:
+		c.expiryWarned = true
+		return expiryWarn
+	}
+
+	return expiryValid
+}
+
+func (e *Epoll) expire(client *Client) {
+	if e.Config.Rango.TokenExpiryAction == "downgrade" {
+		client.ops.Lock()
+		previous := client.GetAuth()
+		if _, err := e.readmit(client, Auth{}); err != nil {
+			log.Printf("Failed to count downgraded connection of %s: %v\n", previous.UID, err)
+		}
+		client.setAuth(Auth{})
+		e.revalidateSubscriptions(client, previous)
+		streams := client.GetSubscriptions()
+		client.ops.Unlock()
+
+		log.Printf("Token of %s expired, connection downgraded\n", previous.UID)
+		e.send(NewSendMessager(client, eventMust("auth.expired", map[string]interface{}{
+			"streams": streams,
+		})))
+
+		return
+	}
+
+	log.Printf("Token of %s expired, closing connection\n", client.GetAuth().UID)
+	e.closeClient(client, websocket.ClosePolicyViolation, "token expired")
+}
@@ -0,0 +1,216 @@
+package routing
+
+import (
+	"errors"
+	"fmt"
+	"strconv"
+	"strings"
+
+	"github.com/shinhagunn/websocket/config"
+)
+
+var ErrConnectionLimit = errors.New("too many connections")
+
+// Policies when a new connection goes over a limit.
+const (
+	LimitReject      = "reject"
+	LimitEvictOldest = "evict_oldest"
+)
+
+// connLimits tracks the open connections per UID and per IP, oldest first,
+// to cap them. It is guarded by the Epoll mutex.
+type connLimits struct {
+	perUID  int
+	perIP   int
+	perRole map[string]int
+	action  string
+
+	byUID map[string][]*Client
+	byIP  map[string][]*Client
+}
+
+// parseRoleLimits parses "role:limit" entries.
+func parseRoleLimits(entries []string) (map[string]int, error) {
+	limits := make(map[string]int, len(entries))
+	for _, entry := range entries {
+		role, limit, ok := strings.Cut(entry, ":")
+		if !ok {
+			return nil, fmt.Errorf("invalid role connection limit %q", entry)
+		}
+
+		n, err := strconv.Atoi(limit)
+		if err != nil {
+			return nil, fmt.Errorf("invalid role connection limit %q", entry)
+		}
+
+		limits[strings.TrimSpace(role)] = n
+	}
+
+	return limits, nil
+}
+
+func newConnLimits(conf config.Rango) (*connLimits, error) {
+	perRole, err := parseRoleLimits(conf.MaxConnectionsPerRole)
+	if err != nil {
+		return nil, err
+	}
+
+	switch conf.ConnectionLimitAction {
+	case LimitReject, LimitEvictOldest:
+	default:
+		return nil, fmt.Errorf("invalid connection limit action %q", conf.ConnectionLimitAction)
+	}
+
+	return &connLimits{
+		perUID:  conf.MaxConnectionsPerUID,
+		perIP:   conf.MaxConnectionsPerIP,
+		perRole: perRole,
+		action:  conf.ConnectionLimitAction,
+		byUID:   make(map[string][]*Client),
+		byIP:    make(map[string][]*Client),
+	}, nil
+}
+
+// uidLimit returns the connection cap of a user, its role cap when one is
+// configured. Zero means unlimited.
+func (l *connLimits) uidLimit(auth Auth) int {
+	if limit, ok := l.perRole[auth.Role]; ok {
+		return limit
+	}
+
+	return l.perUID
+}
+
+func over(list []*Client, limit int) bool {
+	return limit > 0 && len(list) >= limit
+}
+
+// check tells whether a new connection fits within the limits, ignoring the
+// evict policy.
+func (l *connLimits) check(auth Auth, ip string) error {
+	if auth.UID != "" && over(l.byUID[auth.UID], l.uidLimit(auth)) {
+		return ErrConnectionLimit
+	}
+
+	if ip != "" && over(l.byIP[ip], l.perIP) {
+		return ErrConnectionLimit
+	}
+
+	return nil
+}
+
+// admit registers the client under the identity if it fits within the
+// limits. With the evict policy the oldest connections making room are
+// returned, to be closed by the caller.
+func (l *connLimits) admit(client *Client, auth Auth) ([]*Client, error) {
+	if l.action == LimitReject {
+		if err := l.check(auth, client.IP); err != nil {
+			return nil, err
+		}
+	}
+
+	evicted := []*Client{}
+	if auth.UID != "" {
+		for limit := l.uidLimit(auth); over(l.byUID[auth.UID], limit); {
+			oldest := l.byUID[auth.UID][0]
+			l.release(oldest)
+			evicted = append(evicted, oldest)
+		}
+	}
+
+	if client.IP != "" {
+		for over(l.byIP[client.IP], l.perIP) {
+			oldest := l.byIP[client.IP][0]
+			l.release(oldest)
+			evicted = append(evicted, oldest)
+		}
+	}
+
+	l.track(client, auth.UID, client.IP)
+
+	return evicted, nil
+}
+
+// track counts the client under the uid and IP, which it is released by.
+func (l *connLimits) track(client *Client, uid, ip string) {
+	if uid != "" {
+		l.byUID[uid] = append(l.byUID[uid], client)
+	}
+	if ip != "" {
+		l.byIP[ip] = append(l.byIP[ip], client)
+	}
+
+	client.limitUID = uid
+	client.limitIP = ip
+}
+
+func without(list []*Client, client *Client) []*Client {
+	l := make([]*Client, 0, len(list))
+	for _, c := range list {
+		if c != client {
+			l = append(l, c)
+		}
+	}
+	return l
+}
+
+// release stops tracking the client, under the uid and IP it was admitted
+// with.
+func (l *connLimits) release(client *Client) {
+	uid, ip := client.limitUID, client.limitIP
+	if list, ok := l.byUID[uid]; ok {
+		if list = without(list, client); len(list) == 0 {
+			delete(l.byUID, uid)
+		} else {
+			l.byUID[uid] = list
+		}
+	}
+
+	if list, ok := l.byIP[ip]; ok {
+		if list = without(list, client); len(list) == 0 {
+			delete(l.byIP, ip)
+		} else {
+			l.byIP[ip] = list
+		}
+	}
+
+	client.limitUID = ""
+	client.limitIP = ""
+}
+
+// readmit counts the client under the identity it is changing to, after it
+// authenticated over the socket or was downgraded. Like Add it fails with
+// ErrConnectionLimit, keeping the client counted as before, or returns the
+// connections to evict.
+func (e *Epoll) readmit(client *Client, auth Auth) ([]*Client, error) {
+	e.mutex.Lock()
+	defer e.mutex.Unlock()
+
+	if !e.registered(client) || client.limitUID == auth.UID {
+		return nil, nil
+	}
+
+	uid, ip := client.limitUID, client.limitIP
+	e.limits.release(client)
+
+	evicted, err := e.limits.admit(client, auth)
+	if err != nil {
+		e.limits.track(client, uid, ip)
+		return nil, err
+	}
+
+	return evicted, nil
+}
+
+// CheckLimits tells whether a connection of the user from the IP would be
+// accepted, so it can be refused before upgrading. Add enforces the limits.
+func (e *Epoll) CheckLimits(auth Auth, ip string) error {
+	if e.limits.action != LimitReject {
+		return nil
+	}
+
+	e.mutex.RLock()
+	defer e.mutex.RUnlock()
+
+	return e.limits.check(auth, ip)
+}
@@ -0,0 +1,133 @@
+package routing
+
+import (
+	"errors"
+	"testing"
+	"time"
+
+	"github.com/shinhagunn/websocket/config"
+	msgPkg "github.com/shinhagunn/websocket/pkg/message"
+)
+
+func TestAttachRejectsOverLimit(t *testing.T) {
+	e := newTestEpoll(t, func(c *config.Rango) {
+		c.MaxConnectionsPerUID = 1
+		c.MaxConnectionsPerIP = 2
+	})
+
+	first, _ := newAttachedClient(t, e, Auth{UID: "UID1"}, "1.2.3.4")
+
+	if err := e.Attach(NewClient(NewQueueConn(1), Auth{UID: "UID1"})); !errors.Is(err, ErrConnectionLimit) {
+		t.Errorf("second stream of UID1: %v, want ErrConnectionLimit", err)
+	}
+
+	newAttachedClient(t, e, Auth{}, "1.2.3.4")
+	anonymous := NewClient(NewQueueConn(1), Auth{})
+	anonymous.IP = "1.2.3.4"
+	if err := e.Attach(anonymous); !errors.Is(err, ErrConnectionLimit) {
+		t.Errorf("third stream of 1.2.3.4: %v, want ErrConnectionLimit", err)
+	}
+
+	if err := e.Remove(first); err != nil {
+		t.Fatal(err)
+	}
+	if err := e.CheckLimits(Auth{UID: "UID1"}, "5.6.7.8"); err != nil {
+		t.Errorf("UID1 still limited after its stream was removed: %v", err)
+	}
+}
+
+func TestAttachEvictsOldest(t *testing.T) {
+	e := newTestEpoll(t, func(c *config.Rango) {
+		c.MaxConnectionsPerUID = 1
+		c.ConnectionLimitAction = LimitEvictOldest
+	})
+
+	_, oldest := newAttachedClient(t, e, Auth{UID: "UID1"}, "1.2.3.4")
+	_, newest := newAttachedClient(t, e, Auth{UID: "UID1"}, "1.2.3.4")
+
+	if !closed(oldest) || closed(newest) {
+		t.Errorf("oldest closed %v, newest closed %v", closed(oldest), closed(newest))
+	}
+	if clients := e.Clients(); len(clients) != 1 {
+		t.Errorf("%d clients left, want 1", len(clients))
+	}
+}
+
+func TestDowngradeReleasesUIDLimit(t *testing.T) {
+	e := newTestEpoll(t, func(c *config.Rango) {
+		c.MaxConnectionsPerUID = 1
+		c.TokenExpiryAction = "downgrade"
+	})
+
+	client, _ := newAttachedClient(t, e, Auth{UID: "UID1"}, "1.2.3.4")
+	e.expire(client)
+
+	if err := e.CheckLimits(Auth{UID: "UID1"}, ""); err != nil {
+		t.Errorf("UID1 still limited after its only connection was downgraded: %v", err)
+	}
+
+	if err := e.Remove(client); err != nil {
+		t.Fatal(err)
+	}
+	if len(e.limits.byUID) != 0 || len(e.limits.byIP) != 0 {
+		t.Errorf("connections left counted: %v %v", e.limits.byUID, e.limits.byIP)
+	}
+}
+
+func authenticate(e *Epoll, client *Client, uid string) {
+	e.ValidateToken = func(token string) (Auth, error) {
+		return Auth{UID: uid}, nil
+	}
+
+	e.handleAuth(&Request{
+		client: client,
+		Request: msgPkg.Request{
+			Method: "auth",
+			Token:  "token",
+		},
+	})
+}
+
+func TestInBandAuthCountsUIDLimit(t *testing.T) {
+	e := newTestEpoll(t, func(c *config.Rango) {
+		c.MaxConnectionsPerUID = 1
+	})
+
+	newAttachedClient(t, e, Auth{UID: "UID1"}, "1.2.3.4")
+	anonymous, _ := newAttachedClient(t, e, Auth{}, "1.2.3.4")
+
+	authenticate(e, anonymous, "UID1")
+	if uid := anonymous.GetAuth().UID; uid != "" {
+		t.Errorf("authenticated as %q over the UID1 limit", uid)
+	}
+
+	other, _ := newAttachedClient(t, e, Auth{}, "1.2.3.4")
+	authenticate(e, other, "UID2")
+	if uid := other.GetAuth().UID; uid != "UID2" {
+		t.Fatalf("authenticated as %q, want UID2", uid)
+	}
+	if err := e.CheckLimits(Auth{UID: "UID2"}, ""); !errors.Is(err, ErrConnectionLimit) {
+		t.Errorf("connection authenticated in band not counted: %v", err)
+	}
+}
+
+func TestInBandAuthEvictsOldest(t *testing.T) {
+	e := newTestEpoll(t, func(c *config.Rango) {
+		c.MaxConnectionsPerUID = 1
+		c.ConnectionLimitAction = LimitEvictOldest
+	})
+
+	_, oldest := newAttachedClient(t, e, Auth{UID: "UID1"}, "1.2.3.4")
+	anonymous, _ := newAttachedClient(t, e, Auth{}, "1.2.3.4")
+
+	authenticate(e, anonymous, "UID1")
+	if uid := anonymous.GetAuth().UID; uid != "UID1" {
+		t.Fatalf("authenticated as %q, want UID1", uid)
+	}
+
+	select {
+	case <-oldest.Done():
+	case <-time.After(time.Second):
+		t.Error("oldest connection of UID1 not evicted")
+	}
+}
@@ -0,0 +1,270 @@
+package routing
+
+import (
+	"fmt"
+	"log"
+	"sync"
+	"sync/atomic"
+
+	"github.com/gorilla/websocket"
+	"github.com/shinhagunn/websocket/config"
+)
+
+// Policies when a client outbound queue is full.
+const (
+	OverflowDropOldest = "drop_oldest"
+	OverflowDropNewest = "drop_newest"
+	OverflowConflate   = "conflate"
+	OverflowDisconnect = "disconnect"
+)
+
+// Messages a worker writes to a client before serving the others.
+const writeBatch = 32
+
+// QueueStats counts the outbound messages by outcome.
+type QueueStats struct {
+	Queued       atomic.Uint64
+	Dropped      atomic.Uint64
+	Conflated    atomic.Uint64
+	Disconnected atomic.Uint64
+}
+
+type pushResult int
+
+const (
+	pushQueued pushResult = iota
+	pushDropped
+	pushConflated
+	pushOverflowed
+	pushClosed
+)
+
+// outQueue holds the messages waiting to be written to a client. A client is
+// scheduled on the ready list while its queue is not empty, and written by a
+// single worker at a time so its messages keep their order.
+type outQueue struct {
+	mutex     sync.Mutex
+	messages  []SendMessager
+	scheduled bool
+
+	// overflowed is set by the disconnect policy, closed once removed
+	overflowed bool
+	closed     bool
+
+	dropped uint64
+}
+
+// push queues the message, applying the overflow policy when the queue holds
+// size messages. It returns whether the client must be scheduled.
+func (q *outQueue) push(mess SendMessager, size int, policy string) (pushResult, bool) {
+	q.mutex.Lock()
+	defer q.mutex.Unlock()
+
+	if q.closed || q.overflowed {
+		return pushClosed, false
+	}
+
+	res := pushQueued
+	if len(q.messages) >= size {
+		switch policy {
+		case OverflowDropNewest:
+			q.dropped++
+			return pushDropped, false
+		case OverflowDisconnect:
+			q.overflowed = true
+			q.messages = nil
+			res = pushOverflowed
+		case OverflowConflate:
+			// Drop the oldest pending event of the same stream, if any
+			if i := q.pendingEvent(mess.stream); i >= 0 {
+				q.messages = append(q.messages[:i], q.messages[i+1:]...)
+				res = pushConflated
+				break
+			}
+			fallthrough
+		default:
+			q.messages = q.messages[1:]
+			q.dropped++
+			res = pushDropped
+		}
+	}
+
+	if res != pushOverflowed {
+		q.messages = append(q.messages, mess)
+	}
+
+	schedule := !q.scheduled
+	q.scheduled = true
+
+	return res, schedule
+}
+
+func (q *outQueue) pendingEvent(stream string) int {
+	if stream == "" {
+		return -1
+	}
+
+	for i, m := range q.messages {
+		if m.stream == stream {
+			return i
+		}
+	}
+	return -1
+}
+
+// pop takes up to n messages. When the queue is empty the client is no longer
+// scheduled.
+func (q *outQueue) pop(n int) ([]SendMessager, bool) {
+	q.mutex.Lock()
+	defer q.mutex.Unlock()
+
+	if q.overflowed || q.closed || len(q.messages) == 0 {
+		q.scheduled = false
+		return nil, q.overflowed && !q.closed
+	}
+
+	if n > len(q.messages) {
+		n = len(q.messages)
+	}
+
+	batch := make([]SendMessager, n)
+	copy(batch, q.messages)
+	q.messages = q.messages[n:]
+
+	return batch, false
+}
+
+// pending tells whether messages remain, unscheduling the client otherwise.
+func (q *outQueue) pending() bool {
+	q.mutex.Lock()
+	defer q.mutex.Unlock()
+
+	if len(q.messages) == 0 && !q.overflowed {
+		q.scheduled = false
+	}
+	return q.scheduled
+}
+
+func (q *outQueue) close() {
+	q.mutex.Lock()
+	defer q.mutex.Unlock()
+
+	q.closed = true
+	q.messages = nil
+}
+
+func (q *outQueue) stats() (int, uint64) {
+	q.mutex.Lock()
+	defer q.mutex.Unlock()
+
+	return len(q.messages), q.dropped
+}
+
+// Queued returns the number of messages waiting to be written to the client
+// and the number dropped so far.
+func (c *Client) Queued() (int, uint64) {
+	return c.queue.stats()
+}
+
+// readyList holds the clients having messages to write.
+type readyList struct {
+	mutex   sync.Mutex
+	cond    *sync.Cond
+	clients []*Client
+}
+
+func newReadyList() *readyList {
+	l := &readyList{}
+	l.cond = sync.NewCond(&l.mutex)
+	return l
+}
+
+func (l *readyList) push(c *Client) {
+	l.mutex.Lock()
+	l.clients = append(l.clients, c)
+	l.mutex.Unlock()
+
+	l.cond.Signal()
+}
+
+// pop waits for a client.
+func (l *readyList) pop() *Client {
+	l.mutex.Lock()
+	defer l.mutex.Unlock()
+
+	for len(l.clients) == 0 {
+		l.cond.Wait()
+	}
+
+	c := l.clients[0]
+	l.clients[0] = nil
+	l.clients = l.clients[1:]
+
+	return c
+}
+
+func validateOverflowPolicy(conf config.Rango) error {
+	switch conf.SlowConsumerPolicy {
+	case OverflowDropOldest, OverflowDropNewest, OverflowConflate, OverflowDisconnect:
+	default:
+		return fmt.Errorf("invalid slow consumer policy %q", conf.SlowConsumerPolicy)
+	}
+
+	if conf.ClientQueueSize <= 0 {
+		return fmt.Errorf("invalid client queue size %d", conf.ClientQueueSize)
+	}
+
+	return nil
+}
+
+// send queues the message to its client without blocking, so a slow client
+// never holds up the others.
+func (e *Epoll) send(mess SendMessager) {
+	res, schedule := mess.client.queue.push(mess, e.Config.Rango.ClientQueueSize, e.Config.Rango.SlowConsumerPolicy)
+
+	switch res {
+	case pushQueued:
+		e.Queues.Queued.Add(1)
+	case pushDropped:
+		e.Queues.Dropped.Add(1)
+	case pushConflated:
+		e.Queues.Conflated.Add(1)
+	case pushOverflowed:
+		e.Queues.Disconnected.Add(1)
+	}
+
+	if schedule {
+		e.ready.push(mess.client)
+	}
+}
+
+// Write is a worker writing the queued messages of the ready clients.
+func (e *Epoll) Write() {
+	for {
+		client := e.ready.pop()
+
+		batch, overflowed := client.queue.pop(writeBatch)
+		if overflowed {
+			log.Printf("Disconnecting slow consumer %q from %s\n", client.GetAuth().UID, client.IP)
+			e.closeClient(client, websocket.CloseTryAgainLater, "too slow")
+			continue
+		}
+
+		failed := false
+		for _, mess := range batch {
+			if err := client.write(mess); err != nil {
+				log.Printf("Failed to write message %v\n", err)
+				if err := e.Remove(client); err != nil {
+					log.Printf("Failed to remove %v\n", err)
+				}
+				client.Close()
+				failed = true
+				break
+			}
+		}
+
+		if !failed && client.queue.pending() {
+			e.ready.push(client)
+		}
+	}
+}
@@ -0,0 +1,92 @@
+package routing
+
+import (
+	"errors"
+	"sync"
+	"time"
+)
+
+var (
+	ErrQueueFull   = errors.New("outbound queue is full")
+	ErrQueueClosed = errors.New("outbound queue is closed")
+	ErrNotReadable = errors.New("connection does not carry requests")
+)
+
+// Outbound is a message delivered through a QueueConn.
+type Outbound struct {
+	Stream string // subscribed stream of a topic event, empty for responses
+	Seq    uint64 // sequence number of the event within its topic
+	Data   []byte // json message
+}
+
+// QueueConn is a Conn backed by a bounded in-memory queue. It lets plain HTTP
+// transports receive the topic fan-out without a websocket connection; the
+// transport drains Messages until Done is closed.
+type QueueConn struct {
+	queue  chan Outbound
+	closed chan struct{}
+	once   sync.Once
+}
+
+func NewQueueConn(size int) *QueueConn {
+	return &QueueConn{
+		queue:  make(chan Outbound, size),
+		closed: make(chan struct{}),
+	}
+}
+
+// ReadMessage always fails, requests reach queue clients through the HTTP
+// transport instead.
+func (q *QueueConn) ReadMessage() (messageType int, p []byte, err error) {
+	return 0, nil, ErrNotReadable
+}
+
+func (q *QueueConn) WriteMessage(messageType int, data []byte) error {
+	return q.push(Outbound{Data: data})
+}
+
+func (q *QueueConn) WriteEvent(stream string, seq uint64, data []byte) error {
+	return q.push(Outbound{
+		Stream: stream,
+		Seq:    seq,
+		Data:   data,
+	})
+}
+
+func (q *QueueConn) SetWriteDeadline(t time.Time) error {
+	return nil
+}
+
+func (q *QueueConn) Close() error {
+	q.once.Do(func() {
+		close(q.closed)
+	})
+
+	return nil
+}
+
+// Messages returns the queued outbound messages.
+func (q *QueueConn) Messages() <-chan Outbound {
+	return q.queue
+}
+
+// Done is closed once the connection has been closed.
+func (q *QueueConn) Done() <-chan struct{} {
+	return q.closed
+}
+
+// push never blocks: a full queue means the transport stopped draining it.
+func (q *QueueConn) push(m Outbound) error {
+	select {
+	case <-q.closed:
+		return ErrQueueClosed
+	default:
+	}
+
+	select {
+	case q.queue <- m:
+		return nil
+	default:
+		return ErrQueueFull
+	}
+}
@@ -0,0 +1,126 @@
+package routing
+
+import (
+	"strings"
+	"testing"
+)
+
+func queued(stream, msg string) SendMessager {
+	return SendMessager{stream: stream, msg: []byte(msg)}
+}
+
+// contents returns the queued messages joined by commas.
+func contents(q *outQueue) string {
+	msgs := make([]string, len(q.messages))
+	for i, m := range q.messages {
+		msgs[i] = string(m.msg)
+	}
+	return strings.Join(msgs, ",")
+}
+
+func TestOutQueueOverflow(t *testing.T) {
+	tests := []struct {
+		name     string
+		policy   string
+		push     SendMessager
+		res      pushResult
+		messages string
+		dropped  uint64
+	}{
+		{"drop oldest", OverflowDropOldest, queued("btcusd.trades", "c"), pushDropped, "b,c", 1},
+		{"drop newest", OverflowDropNewest, queued("btcusd.trades", "c"), pushDropped, "a,b", 1},
+		{"conflate", OverflowConflate, queued("btcusd.trades", "c"), pushConflated, "b,c", 0},
+		{"conflate other stream", OverflowConflate, queued("ethusd.trades", "c"), pushDropped, "b,c", 1},
+		{"conflate response", OverflowConflate, queued("", "c"), pushDropped, "b,c", 1},
+		{"disconnect", OverflowDisconnect, queued("btcusd.trades", "c"), pushOverflowed, "", 0},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			q := &outQueue{}
+			q.push(queued("btcusd.trades", "a"), 2, tt.policy)
+			q.push(queued("", "b"), 2, tt.policy)
+
+			res, schedule := q.push(tt.push, 2, tt.policy)
+			if res != tt.res {
+				t.Errorf("push result %d, want %d", res, tt.res)
+			}
+			if schedule {
+				t.Error("scheduled client scheduled again")
+			}
+
+			if got := contents(q); got != tt.messages {
+				t.Errorf("queued %q, want %q", got, tt.messages)
+			}
+
+			if _, dropped := q.stats(); dropped != tt.dropped {
+				t.Errorf("dropped %d, want %d", dropped, tt.dropped)
+			}
+		})
+	}
+}
+
+func TestOutQueueDisconnect(t *testing.T) {
+	q := &outQueue{}
+	q.push(queued("", "a"), 1, OverflowDisconnect)
+	q.push(queued("", "b"), 1, OverflowDisconnect)
+
+	if res, _ := q.push(queued("", "c"), 1, OverflowDisconnect); res != pushClosed {
+		t.Errorf("push to an overflowed queue returned %d", res)
+	}
+
+	// The worker pops nothing and closes the client
+	if batch, overflowed := q.pop(writeBatch); len(batch) != 0 || !overflowed {
+		t.Errorf("pop of an overflowed queue returned %d messages, overflowed %v", len(batch), overflowed)
+	}
+
+	q.close()
+	if _, overflowed := q.pop(writeBatch); overflowed {
+		t.Error("removed client reported overflowed")
+	}
+}
+
+func TestOutQueueClosed(t *testing.T) {
+	for _, policy := range []string{OverflowDropOldest, OverflowDropNewest, OverflowConflate, OverflowDisconnect} {
+		q := &outQueue{}
+		q.push(queued("", "a"), 2, policy)
+		q.close()
+
+		if res, schedule := q.push(queued("", "b"), 2, policy); res != pushClosed || schedule {
+			t.Errorf("%s: push to a closed queue returned %d, schedule %v", policy, res, schedule)
+		}
+
+		if n, _ := q.stats(); n != 0 {
+			t.Errorf("%s: %d messages left in a closed queue", policy, n)
+		}
+	}
+}
+
+func TestOutQueueScheduling(t *testing.T) {
+	q := &outQueue{}
+
+	if res, schedule := q.push(queued("", "a"), 4, OverflowDropOldest); res != pushQueued || !schedule {
+		t.Fatalf("first push returned %d, schedule %v", res, schedule)
+	}
+	if _, schedule := q.push(queued("", "b"), 4, OverflowDropOldest); schedule {
+		t.Error("scheduled client scheduled again")
+	}
+
+	batch, _ := q.pop(1)
+	if len(batch) != 1 || string(batch[0].msg) != "a" || !q.pending() {
+		t.Fatal("first message not popped in order")
+	}
+
+	batch, _ = q.pop(writeBatch)
+	if len(batch) != 1 || string(batch[0].msg) != "b" {
+		t.Fatal("second message not popped")
+	}
+
+	if q.pending() {
+		t.Error("empty queue still scheduled")
+	}
+
+	if _, schedule := q.push(queued("", "c"), 4, OverflowDropOldest); !schedule {
+		t.Error("unscheduled client not scheduled again")
+	}
+}
@@ -0,0 +1,220 @@
+package routing
+
+import (
+	"fmt"
+	"log"
+	"math"
+	"sync"
+	"sync/atomic"
+	"syscall"
+	"time"
+
+	"github.com/gorilla/websocket"
+	"github.com/shinhagunn/websocket/config"
+	msgPkg "github.com/shinhagunn/websocket/pkg/message"
+
+	"golang.org/x/sys/unix"
+)
+
+// Policies when a connection sends messages faster than its rate.
+const (
+	RateReject     = "reject"
+	RateThrottle   = "throttle"
+	RateDisconnect = "disconnect"
+)
+
+var (
+	errMessageRate      = &msgPkg.CodedError{Code: "rate_limited", Message: "too many messages"}
+	errSubscriptionRate = &msgPkg.CodedError{Code: "subscription_rate_limited", Message: "too many subscription changes"}
+)
+
+// tokenBucket refills rate tokens per second up to burst.
+type tokenBucket struct {
+	rate  float64
+	burst float64
+
+	mutex  sync.Mutex
+	tokens float64
+	last   time.Time
+}
+
+// newTokenBucket returns nil, which never limits, when rate is 0.
+func newTokenBucket(rate float64, burst int) *tokenBucket {
+	if rate <= 0 {
+		return nil
+	}
+
+	b := math.Max(float64(burst), 1)
+	return &tokenBucket{
+		rate:   rate,
+		burst:  b,
+		tokens: b,
+		last:   time.Now(),
+	}
+}
+
+// take consumes n tokens. When they are not available nothing is consumed
+// and the time until they are is returned.
+func (b *tokenBucket) take(n int, now time.Time) (bool, time.Duration) {
+	if b == nil || n <= 0 {
+		return true, 0
+	}
+
+	b.mutex.Lock()
+	defer b.mutex.Unlock()
+
+	b.tokens = math.Min(b.burst, b.tokens+now.Sub(b.last).Seconds()*b.rate)
+	b.last = now
+
+	// Requests larger than the burst only need a full bucket
+	cost := math.Min(float64(n), b.burst)
+	if b.tokens >= cost {
+		b.tokens -= cost
+		return true, 0
+	}
+
+	return false, time.Duration((cost - b.tokens) / b.rate * float64(time.Second))
+}
+
+// rateLimits are the buckets of a connection.
+type rateLimits struct {
+	messages      *tokenBucket
+	subscriptions *tokenBucket
+
+	// Messages over a rate
+	limited atomic.Uint64
+}
+
+// RateLimitStats counts the messages over a rate by action taken.
+type RateLimitStats struct {
+	Rejected     atomic.Uint64
+	Throttled    atomic.Uint64
+	Disconnected atomic.Uint64
+}
+
+func validateRateLimitAction(conf config.Rango) error {
+	switch conf.RateLimitAction {
+	case RateReject, RateThrottle, RateDisconnect:
+		return nil
+	default:
+		return fmt.Errorf("invalid rate limit action %q", conf.RateLimitAction)
+	}
+}
+
+func (e *Epoll) newRateLimits() *rateLimits {
+	conf := e.Config.Rango
+	return &rateLimits{
+		messages:      newTokenBucket(conf.MessageRate, conf.MessageBurst),
+		subscriptions: newTokenBucket(conf.SubscriptionRate, conf.SubscriptionBurst),
+	}
+}
+
+// RateLimited returns how many messages of the client went over a rate.
+func (c *Client) RateLimited() uint64 {
+	if c.rates == nil {
+		return 0
+	}
+	return c.rates.limited.Load()
+}
+
+// allow checks a request of an attached client against the client rates.
+// Requests over a rate are handled according to the configured action, in
+// which case allow returns false and the caller must drop the request.
+func (e *Epoll) allow(req *Request) bool {
+	retry := func() bool {
+		if !e.allow(req) {
+			return false
+		}
+		e.handleRequest(req)
+		return true
+	}
+
+	return e.allowMessage(req.client, retry) && e.allowSubscriptions(req, retry)
+}
+
+// allowMessage charges one message to the client rate, whatever the message
+// is. retry handles the message again once a throttled client is resumed, it
+// returns false when the client was throttled again.
+func (e *Epoll) allowMessage(client *Client, retry func() bool) bool {
+	rates := client.rates
+	if rates == nil {
+		return true
+	}
+
+	ok, wait := rates.messages.take(1, time.Now())
+	if !ok {
+		e.limit(client, errMessageRate, wait, retry)
+	}
+
+	return ok
+}
+
+// allowSubscriptions charges the subscription changes of the request to the
+// client rate, like allowMessage.
+func (e *Epoll) allowSubscriptions(req *Request, retry func() bool) bool {
+	rates := req.client.rates
+	if rates == nil || (req.Method != "subscribe" && req.Method != "unsubscribe") {
+		return true
+	}
+
+	ok, wait := rates.subscriptions.take(len(req.Streams), time.Now())
+	if !ok {
+		e.limit(req.client, errSubscriptionRate, wait, retry)
+	}
+
+	return ok
+}
+
+// limit applies the configured action to a client that went over a rate.
+func (e *Epoll) limit(client *Client, limit *msgPkg.CodedError, wait time.Duration, retry func() bool) {
+	client.rates.limited.Add(1)
+	log.Printf("Rate limited %q from %s: %v\n", client.GetAuth().UID, client.IP, limit)
+
+	action := e.Config.Rango.RateLimitAction
+	// Only sockets read through epoll can be paused
+	if action == RateThrottle && client.fd < 0 {
+		action = RateReject
+	}
+
+	switch action {
+	case RateThrottle:
+		e.RateLimits.Throttled.Add(1)
+		e.throttle(client, wait, retry)
+	case RateDisconnect:
+		e.RateLimits.Disconnected.Add(1)
+		e.closeClient(client, websocket.ClosePolicyViolation, limit.Message)
+	default:
+		e.RateLimits.Rejected.Add(1)
+		e.send(NewSendMessager(client, []byte(responseMust(limit, nil))))
+	}
+}
+
+// throttle stops reading the client and retries its message once wait is
+// over, so a client sending too fast is slowed down to its rate.
+func (e *Epoll) throttle(client *Client, wait time.Duration, retry func() bool) {
+	fd := client.fd
+
+	if err := unix.EpollCtl(e.pollFD(fd), syscall.EPOLL_CTL_MOD, fd, &unix.EpollEvent{Fd: int32(fd)}); err != nil {
+		log.Printf("Failed to throttle %v\n", err)
+	}
+
+	time.AfterFunc(wait, func() {
+		e.mutex.RLock()
+		registered := e.Connections[fd] == client
+		e.mutex.RUnlock()
+
+		if !registered {
+			return
+		}
+
+		// Throttled again, rescheduled by the retry
+		if !retry() {
+			return
+		}
+
+		err := unix.EpollCtl(e.pollFD(fd), syscall.EPOLL_CTL_MOD, fd, &unix.EpollEvent{Events: unix.POLLIN | unix.POLLHUP, Fd: int32(fd)})
+		if err != nil {
+			log.Printf("Failed to resume %v\n", err)
+		}
+	})
+}
@@ -0,0 +1,119 @@
+package routing
+
+import (
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/shinhagunn/websocket/config"
+)
+
+func TestTokenBucket(t *testing.T) {
+	now := time.Now()
+	b := newTokenBucket(2, 3)
+	b.last = now
+
+	if ok, _ := b.take(3, now); !ok {
+		t.Fatal("burst refused")
+	}
+
+	ok, wait := b.take(1, now)
+	if ok || wait != 500*time.Millisecond {
+		t.Errorf("empty bucket took a token or waits %v, want 500ms", wait)
+	}
+
+	if ok, _ := b.take(1, now.Add(500*time.Millisecond)); !ok {
+		t.Error("refilled token refused")
+	}
+
+	if ok, _ := newTokenBucket(0, 0).take(1000, now); !ok {
+		t.Error("disabled bucket limited")
+	}
+}
+
+func TestAttachedClientSubscriptionRate(t *testing.T) {
+	for _, action := range []string{RateReject, RateThrottle} {
+		e := newTestEpoll(t, func(c *config.Rango) {
+			c.SubscriptionRate = 0.001
+			c.SubscriptionBurst = 2
+			c.RateLimitAction = action
+		})
+
+		client, _ := newAttachedClient(t, e, Auth{}, "1.2.3.4")
+
+		if !e.Subscribe(client, []string{"btcusd.trades", "ethusd.trades"}, nil) {
+			t.Fatalf("%s: subscription within the burst refused", action)
+		}
+
+		if e.Unsubscribe(client, []string{"btcusd.trades"}) {
+			t.Errorf("%s: subscription change over the rate handled", action)
+		}
+
+		if streams := client.GetSubscriptions(); len(streams) != 2 {
+			t.Errorf("%s: subscriptions %v after a refused unsubscribe", action, streams)
+		}
+
+		if client.RateLimited() != 1 || e.RateLimits.Rejected.Load() != 1 {
+			t.Errorf("%s: limited %d, rejected %d", action, client.RateLimited(), e.RateLimits.Rejected.Load())
+		}
+	}
+}
+
+func TestMessageRateCountsEveryFrame(t *testing.T) {
+	e := newTestEpoll(t, func(c *config.Rango) {
+		c.MessageRate = 0.001
+		c.MessageBurst = 2
+		c.RateLimitAction = RateReject
+	})
+
+	client, _ := newAttachedClient(t, e, Auth{}, "1.2.3.4")
+
+	if !e.handleMessage(client, []byte("ping")) || !e.handleMessage(client, []byte("garbage")) {
+		t.Fatal("messages within the burst dropped")
+	}
+
+	// Neither pings nor malformed requests are answered over the rate
+	for _, mess := range []string{"ping", "garbage"} {
+		if e.handleMessage(client, []byte(mess)) {
+			t.Errorf("%s over the rate handled", mess)
+		}
+	}
+
+	batch, _ := client.queue.pop(10)
+	if len(batch) != 4 {
+		t.Fatalf("%d replies queued, want 4", len(batch))
+	}
+
+	if string(batch[0].msg) != "pong" {
+		t.Errorf("ping answered %s", batch[0].msg)
+	}
+
+	for _, mess := range batch[2:] {
+		if !strings.Contains(string(mess.msg), errMessageRate.Code) {
+			t.Errorf("message over the rate answered %s", mess.msg)
+		}
+	}
+
+	if client.RateLimited() != 2 || e.RateLimits.Rejected.Load() != 2 {
+		t.Errorf("limited %d, rejected %d", client.RateLimited(), e.RateLimits.Rejected.Load())
+	}
+}
+
+func TestMessageRateDisconnect(t *testing.T) {
+	e := newTestEpoll(t, func(c *config.Rango) {
+		c.MessageRate = 0.001
+		c.MessageBurst = 1
+		c.RateLimitAction = RateDisconnect
+	})
+
+	client, conn := newAttachedClient(t, e, Auth{}, "1.2.3.4")
+
+	e.handleMessage(client, []byte("ping"))
+	if e.handleMessage(client, []byte("ping")) {
+		t.Error("ping over the rate handled")
+	}
+
+	if !closed(conn) || e.RateLimits.Disconnected.Load() != 1 {
+		t.Error("client over the rate not disconnected")
+	}
+}
@@ -0,0 +1,147 @@
+package routing
+
+import (
+	"hash/fnv"
+	"sync"
+	"time"
+)
+
+// Number of independently locked parts of a topic registry.
+const registryShards = 64
+
+type topicShard struct {
+	mutex  sync.RWMutex
+	topics map[string]*Topic
+}
+
+// topicRegistry maps keys to topics. Keys are spread over shards with their
+// own lock, and each topic has its own, so events of different topics are
+// routed in parallel.
+type topicRegistry struct {
+	shards [registryShards]topicShard
+	send   func(SendMessager)
+
+	// How long topics without clients keep their events for resuming
+	// clients, they are removed with their last client when 0
+	retention time.Duration
+}
+
+func newTopicRegistry(send func(SendMessager), retention time.Duration) *topicRegistry {
+	r := &topicRegistry{
+		send:      send,
+		retention: retention,
+	}
+	for i := range r.shards {
+		r.shards[i].topics = make(map[string]*Topic)
+	}
+
+	return r
+}
+
+func (r *topicRegistry) shard(key string) *topicShard {
+	h := fnv.New32a()
+	h.Write([]byte(key))
+	return &r.shards[h.Sum32()%registryShards]
+}
+
+func (r *topicRegistry) get(key string) (*Topic, bool) {
+	s := r.shard(key)
+	s.mutex.RLock()
+	defer s.mutex.RUnlock()
+
+	t, ok := s.topics[key]
+	return t, ok
+}
+
+func (r *topicRegistry) getOrCreate(key, name string) *Topic {
+	if t, ok := r.get(key); ok {
+		return t
+	}
+
+	s := r.shard(key)
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+
+	t, ok := s.topics[key]
+	if !ok {
+		t = NewTopic(r.send, name)
+		s.topics[key] = t
+	}
+
+	return t
+}
+
+// subscribe adds the client to the topic of key, created with the stream name
+// when missing, and replays its buffered events after lastSeq. It reports
+// whether the client was not subscribed yet.
+func (r *topicRegistry) subscribe(key, name string, c *Client, lastSeq map[string]uint64) bool {
+	for {
+		added, err := r.getOrCreate(key, name).subscribe(c, lastSeq)
+		// Removed by a concurrent unsubscribe after lookup, create it again
+		if err == errTopicRemoved {
+			continue
+		}
+
+		return added
+	}
+}
+
+// unsubscribe removes the client from the topic of key, removing the topic
+// with its last client when topics are not retained. It reports whether the
+// client was subscribed.
+func (r *topicRegistry) unsubscribe(key string, c *Client) bool {
+	s := r.shard(key)
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+
+	t, ok := s.topics[key]
+	if !ok {
+		return false
+	}
+
+	removed := t.unsubscribe(c)
+	if r.retention == 0 && t.removeIfIdle(time.Now(), 0) {
+		delete(s.topics, key)
+	}
+
+	return removed
+}
+
+// reap removes the topics left without clients for longer than the
+// retention.
+func (r *topicRegistry) reap(now time.Time) {
+	for i := range r.shards {
+		s := &r.shards[i]
+		s.mutex.Lock()
+		for key, t := range s.topics {
+			if t.removeIfIdle(now, r.retention) {
+				delete(s.topics, key)
+			}
+		}
+		s.mutex.Unlock()
+	}
+}
+
+// broadcast sends the event to the clients of the topic of key, reporting
+// whether there is one.
+func (r *topicRegistry) broadcast(key string, ev *Event) bool {
+	t, ok := r.get(key)
+	if ok {
+		t.broadcast(ev)
+	}
+
+	return ok
+}
+
+// len returns the number of topics.
+func (r *topicRegistry) len() int {
+	n := 0
+	for i := range r.shards {
+		s := &r.shards[i]
+		s.mutex.RLock()
+		n += len(s.topics)
+		s.mutex.RUnlock()
+	}
+
+	return n
+}
@@ -0,0 +1,184 @@
+package routing
+
+import (
+	"fmt"
+	"sync"
+	"sync/atomic"
+	"testing"
+)
+
+func TestRegistrySubscribeRetriesRemovedTopic(t *testing.T) {
+	r := newTopicRegistry(func(SendMessager) {}, 0)
+
+	first := newTestClient()
+	r.subscribe("btcusd.trades", "btcusd.trades", first, nil)
+	stale, _ := r.get("btcusd.trades")
+
+	// The last client leaving removes the topic
+	if !r.unsubscribe("btcusd.trades", first) {
+		t.Fatal("subscribed client not removed")
+	}
+	if _, ok := r.get("btcusd.trades"); ok || r.len() != 0 {
+		t.Fatal("empty topic kept")
+	}
+
+	// A subscriber that looked the topic up before it was removed
+	if _, err := stale.subscribe(newTestClient(), nil); err != errTopicRemoved {
+		t.Fatalf("subscribe to a removed topic: %v", err)
+	}
+
+	client := newTestClient()
+	if !r.subscribe("btcusd.trades", "btcusd.trades", client, nil) {
+		t.Fatal("client not subscribed")
+	}
+
+	topic, ok := r.get("btcusd.trades")
+	if !ok || topic == stale || topic.len() != 1 {
+		t.Error("topic not recreated")
+	}
+}
+
+// TestRegistryConcurrentChurn subscribes and unsubscribes clients of the
+// same keys while events are broadcasted, so topics are removed and
+// recreated under subscribers racing with them. Run it with -race.
+func TestRegistryConcurrentChurn(t *testing.T) {
+	var sent atomic.Uint64
+	r := newTopicRegistry(func(SendMessager) { sent.Add(1) }, 0)
+
+	keys := []string{"btcusd.trades", "ethusd.trades"}
+	const (
+		workers = 16
+		rounds  = 500
+	)
+
+	var wg sync.WaitGroup
+	stop := make(chan struct{})
+
+	for _, key := range keys {
+		wg.Add(1)
+		go func(key string) {
+			defer wg.Done()
+			for {
+				select {
+				case <-stop:
+					return
+				default:
+					r.broadcast(key, newTestEvent())
+				}
+			}
+		}(key)
+	}
+
+	var churn sync.WaitGroup
+	errs := make(chan error, workers)
+	for i := 0; i < workers; i++ {
+		churn.Add(1)
+		go func(i int) {
+			defer churn.Done()
+			key := keys[i%len(keys)]
+
+			for n := 0; n < rounds; n++ {
+				c := newTestClient()
+				if !r.subscribe(key, key, c, nil) {
+					errs <- fmt.Errorf("new client reported subscribed")
+					return
+				}
+
+				// Only this worker removes c, so the topic holding it
+				// must be the registered one
+				topic, ok := r.get(key)
+				if !ok {
+					errs <- fmt.Errorf("subscribed to a removed topic of %s", key)
+					return
+				}
+				topic.mutex.Lock()
+				_, in := topic.clients[c]
+				topic.mutex.Unlock()
+				if !in {
+					errs <- fmt.Errorf("subscribed to a removed topic of %s", key)
+					return
+				}
+
+				if !r.unsubscribe(key, c) {
+					errs <- fmt.Errorf("subscribed client not removed")
+					return
+				}
+			}
+		}(i)
+	}
+
+	churn.Wait()
+	close(stop)
+	wg.Wait()
+	close(errs)
+
+	for err := range errs {
+		t.Error(err)
+	}
+
+	if n := r.len(); n != 0 {
+		t.Errorf("%d empty topics left", n)
+	}
+}
+
+// BenchmarkRegistryBroadcastParallel routes events to many topics from
+// parallel goroutines, as the shards and topic locks allow.
+func BenchmarkRegistryBroadcastParallel(b *testing.B) {
+	const (
+		topics  = 1000
+		clients = 10
+	)
+
+	var sent atomic.Uint64
+	r := newTopicRegistry(func(SendMessager) { sent.Add(1) }, 0)
+
+	keys := make([]string, topics)
+	for i := range keys {
+		keys[i] = fmt.Sprintf("market%d.trades", i)
+		for j := 0; j < clients; j++ {
+			r.subscribe(keys[i], keys[i], newTestClient(), nil)
+		}
+	}
+
+	body := []byte(`{"tid":123456,"taker_type":"buy","date":1700000000,"price":"42123.45","amount":"0.01500000"}`)
+	var next atomic.Uint64
+
+	b.ReportAllocs()
+	b.ResetTimer()
+	b.RunParallel(func(pb *testing.PB) {
+		for pb.Next() {
+			key := keys[next.Add(1)%topics]
+			r.broadcast(key, &Event{
+				Topic: key,
+				Body:  body,
+			})
+		}
+	})
+	b.StopTimer()
+
+	b.ReportMetric(float64(sent.Load())/float64(b.N), "messages/op")
+}
+
+// BenchmarkRegistrySubscribeParallel subscribes and unsubscribes clients of
+// many topics from parallel goroutines.
+func BenchmarkRegistrySubscribeParallel(b *testing.B) {
+	r := newTopicRegistry(func(SendMessager) {}, 0)
+
+	keys := make([]string, 1000)
+	for i := range keys {
+		keys[i] = fmt.Sprintf("market%d.trades", i)
+	}
+
+	var next atomic.Uint64
+
+	b.ReportAllocs()
+	b.ResetTimer()
+	b.RunParallel(func(pb *testing.PB) {
+		c := newTestClient()
+		for pb.Next() {
+			key := keys[next.Add(1)%uint64(len(keys))]
+			r.subscribe(key, key, c, nil)
+			r.unsubscribe(key, c)
+		}
+	})
+}
@@ -0,0 +1,91 @@
+package routing
+
+import (
+	"context"
+	"encoding/json"
+	"log"
+	"time"
+
+	"github.com/gorilla/websocket"
+	"github.com/shinhagunn/websocket/pkg/auth"
+	"github.com/twmb/franz-go/pkg/kgo"
+)
+
+// Revoke records the revocation and closes the matching connections, it
+// returns the number of connections closed.
+func (e *Epoll) Revoke(r auth.Revocation) (int, error) {
+	if e.Revocations != nil {
+		if err := e.Revocations.Revoke(r); err != nil {
+			return 0, err
+		}
+	} else if err := r.Validate(); err != nil {
+		return 0, err
+	}
+
+	matching := []*Client{}
+
+	for _, client := range e.Clients() {
+		a := client.GetAuth()
+		if r.Matches(a.TokenID, a.UID) {
+			matching = append(matching, client)
+		}
+	}
+
+	for _, client := range matching {
+		log.Printf("Closing revoked connection of %s\n", client.GetAuth().UID)
+		e.closeClient(client, websocket.ClosePolicyViolation, "token revoked")
+	}
+
+	return len(matching), nil
+}
+
+// ReceiveRevocation handles revocations published as JSON on a Kafka topic.
+func (e *Epoll) ReceiveRevocation(msg *kgo.Record) {
+	var r auth.Revocation
+	if err := json.Unmarshal(msg.Value, &r); err != nil {
+		log.Printf("Invalid revocation %s: %v\n", msg.Value, err)
+		return
+	}
+
+	if _, err := e.Revoke(r); err != nil {
+		log.Printf("Failed to revoke %v: %v\n", r, err)
+	}
+}
+
+// ConsumeRevocations applies the revocations published on
+// RANGO_REVOCATION_KAFKA_TOPIC, it never returns. Every server reads the whole
+// topic, starting with the revocations of the last RANGO_REVOCATION_TTL so
+// they survive restarts.
+func (e *Epoll) ConsumeRevocations() {
+	conf := e.Config.Rango
+	client, err := kgo.NewClient(
+		kgo.SeedBrokers(conf.RevocationKafkaBrokers...),
+		kgo.ConsumeTopics(conf.RevocationKafkaTopic),
+		kgo.ConsumeResetOffset(kgo.NewOffset().AfterMilli(time.Now().Add(-conf.RevocationTTL).UnixMilli())),
+	)
+	if err != nil {
+		log.Printf("Failed to consume revocations: %v\n", err)
+		return
+	}
+	defer client.Close()
+
+	for {
+		fetches := client.PollFetches(context.Background())
+		fetches.EachError(func(topic string, partition int32, err error) {
+			log.Printf("Failed to fetch revocations from %s/%d: %v\n", topic, partition, err)
+		})
+		fetches.EachRecord(e.ReceiveRevocation)
+	}
+}
+
+// closeClient sends a close frame with the reason to websocket clients, then
+// removes the client.
+func (e *Epoll) closeClient(client *Client, code int, reason string) {
+	if conn, ok := client.conn.(*websocket.Conn); ok {
+		conn.WriteControl(websocket.CloseMessage, websocket.FormatCloseMessage(code, reason), time.Now().Add(writeWait))
+	}
+
+	if err := e.Remove(client); err != nil {
+		log.Printf("Failed to remove %v\n", err)
+	}
+}
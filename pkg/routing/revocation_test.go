@@ -0,0 +1,27 @@
+package routing
+
+import (
+	"testing"
+
+	"github.com/shinhagunn/websocket/pkg/auth"
+)
+
+func TestRevokeClosesAttachedClients(t *testing.T) {
+	e := newTestEpoll(t, nil)
+
+	_, revoked := newAttachedClient(t, e, Auth{UID: "UID1", TokenID: "jti1"}, "1.2.3.4")
+	_, other := newAttachedClient(t, e, Auth{UID: "UID2", TokenID: "jti2"}, "1.2.3.4")
+
+	n, err := e.Revoke(auth.Revocation{UID: "UID1"})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if n != 1 || !closed(revoked) || closed(other) {
+		t.Errorf("revoked %d connections, UID1 closed %v, UID2 closed %v", n, closed(revoked), closed(other))
+	}
+
+	if clients := e.Clients(); len(clients) != 1 {
+		t.Errorf("%d clients left, want 1", len(clients))
+	}
+}
@@ -1,103 +1,88 @@
 package routing
 
-import "log"
+import (
+	"log"
 
-func (e *Epoll) handleSubscribe(req *Request) {
-	e.mutex.Lock()
-	defer e.mutex.Unlock()
+	"github.com/shinhagunn/websocket/pkg/policy"
+)
+
+// handleSubscribe subscribes the client to the streams it is allowed to, it
+// returns false for removed clients, which are left unsubscribed.
+func (e *Epoll) handleSubscribe(req *Request) bool {
+	req.client.ops.Lock()
+	defer req.client.ops.Unlock()
+
+	if req.client.closed {
+		return false
+	}
 
 	for _, t := range req.Streams {
+		decision := e.authorize(t, req.client.GetAuth())
+		if !decision.Allowed {
+			if !isPublicStream(t) {
+				e.auditSubscription(req.client, t, decision)
+			}
+
+			log.Printf("Subscription of %q from %s to %s denied: %s\n", req.client.GetAuth().UID, req.client.IP, t, decision.Reason)
+			e.send(NewSendMessager(req.client, []byte(responseMust(nil, map[string]interface{}{
+				"message": "cannot subscribe to " + t,
+			}))))
+
+			continue
+		}
+
 		switch {
 		case isPrivateStream(t):
-			e.subscribePrivate(t, req)
+			e.subscribePrivate(t, req, decision)
 		case isPrefixedStream(t):
-			e.subscribePrefixed(t, req)
+			e.subscribePrefixed(t, req, decision)
 		default:
 			e.subscribePublic(t, req)
 		}
 	}
 
-	e.send <- NewSendMessager(req.client, []byte(responseMust(nil, map[string]interface{}{
+	e.send(NewSendMessager(req.client, []byte(responseMust(nil, map[string]interface{}{
 		"message": "subscribed",
 		"streams": req.client.GetSubscriptions(),
-	})))
+	}))))
+
+	return true
 }
 
 func (e *Epoll) subscribePublic(t string, req *Request) {
-	topic, ok := e.PublicTopics[t]
-	if !ok {
-		topic = NewTopic(e.send)
-		e.PublicTopics[t] = topic
-	}
-
-	if topic.subscribe(req.client) {
+	if e.public.subscribe(t, t, req.client, req.lastSeq) {
 		req.client.SubscribePublic(t)
 	}
 }
 
-func (e *Epoll) premittedRBAC(prefix string, auth Auth) bool {
-	rbac := e.Config.Rango.RbacAdmin
-	if prefix == "system" {
-		rbac = e.Config.Rango.RbacSystem
-	}
-
-	for _, role := range rbac {
-		if role == auth.Role {
-			return true
-		}
-	}
-
-	return false
+// authorize evaluates a subscription to the stream against the policy and
+// the minimum level of private streams.
+func (e *Epoll) authorize(stream string, auth Auth) policy.Decision {
+	return policy.Authorize(e.Policy, policy.Subject{
+		UID:   auth.UID,
+		Role:  auth.Role,
+		Level: auth.Level,
+	}, stream, e.Config.Rango.PrivateMinLevel)
 }
 
-func (e *Epoll) subscribePrefixed(prefixed string, req *Request) {
-	prefix, t := splitPrefixedTopic(prefixed)
+func (e *Epoll) subscribePrefixed(prefixed string, req *Request, decision policy.Decision) {
+	e.auditSubscription(req.client, prefixed, decision)
 
-	if !e.premittedRBAC(prefix, req.client.GetAuth()) {
-		e.send <- NewSendMessager(req.client, []byte(responseMust(nil, map[string]interface{}{
-			"message": "cannot subscribe to " + prefixed,
-		})))
-
-		return
-	}
-
-	topics, ok := e.PrefixedTopics[prefix]
-	if !ok {
-		topics := make(map[string]*Topic, 0)
-		e.PrefixedTopics[prefix] = topics
-	}
-
-	topic, ok := topics[t]
-	if !ok {
-		topic = NewTopic(e.send)
-		e.PrefixedTopics[prefix][t] = topic
-	}
-
-	if topic.subscribe(req.client) {
+	if e.prefixed.subscribe(prefixed, prefixed, req.client, req.lastSeq) {
 		req.client.SubscribePublic(prefixed)
 	}
 }
 
-func (e *Epoll) subscribePrivate(t string, req *Request) {
+func (e *Epoll) subscribePrivate(t string, req *Request, decision policy.Decision) {
 	uid := req.client.GetAuth().UID
 	if uid == "" {
 		log.Printf("Anonymous user tried to subscribe to private stream %s\n", t)
+		e.auditSubscription(req.client, t, policy.Decision{Reason: "anonymous"})
 		return
 	}
+	e.auditSubscription(req.client, t, decision)
 
-	uTopics, ok := e.PrivateTopics[uid]
-	if !ok {
-		uTopics = make(map[string]*Topic, 3)
-		e.PrivateTopics[uid] = uTopics
-	}
-
-	topic, ok := uTopics[t]
-	if !ok {
-		topic = NewTopic(e.send)
-		uTopics[t] = topic
-	}
-
-	if topic.subscribe(req.client) {
+	if e.private.subscribe(privateKey(uid, t), t, req.client, req.lastSeq) {
 		req.client.SubscribePrivate(t)
 	}
 }
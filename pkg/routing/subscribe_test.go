@@ -0,0 +1,76 @@
+package routing
+
+import (
+	"testing"
+	"time"
+
+	"github.com/shinhagunn/websocket/config"
+	"github.com/shinhagunn/websocket/pkg/policy"
+)
+
+func TestAuthorizeEnforcesPrivateMinLevel(t *testing.T) {
+	e := newTestEpoll(t, func(c *config.Rango) {
+		c.PrivateMinLevel = 3
+	})
+
+	// A policy file allowing every private stream
+	e.Policy = policy.NewEngine(&policy.Policy{
+		Defaults: map[string]string{policy.ScopePrivate: policy.EffectAllow},
+	})
+
+	if d := e.authorize("balances", Auth{UID: "UID1", Level: 2}); d.Allowed {
+		t.Error("private stream allowed below RANGO_PRIVATE_MIN_LEVEL")
+	}
+
+	if d := e.authorize("balances", Auth{UID: "UID1", Level: 3}); !d.Allowed {
+		t.Errorf("private stream denied at the minimum level: %s", d.Reason)
+	}
+}
+
+func TestRevalidateClientsAfterPolicyChange(t *testing.T) {
+	e := newTestEpoll(t, nil)
+	e.Policy = policy.NewEngine(policy.FromRBAC([]string{"admin"}, nil, 0))
+
+	client, _ := newAttachedClient(t, e, Auth{UID: "UID1", Role: "admin"}, "1.2.3.4")
+	e.Subscribe(client, []string{"btcusd.trades", "admin.btcusd.orders"}, nil)
+
+	if streams := client.GetSubscriptions(); len(streams) != 2 {
+		t.Fatalf("subscribed to %v", streams)
+	}
+
+	e.Policy.Set(policy.FromRBAC([]string{"superadmin"}, nil, 0))
+	e.revalidateClients()
+
+	if streams := client.GetSubscriptions(); len(streams) != 1 || streams[0] != "btcusd.trades" {
+		t.Errorf("subscriptions after the reload %v", streams)
+	}
+
+	if topic, ok := e.prefixed.get("admin.btcusd.orders"); ok && topic.len() != 0 {
+		t.Error("client still in the denied topic")
+	}
+}
+
+func TestSubscribeAfterRemove(t *testing.T) {
+	e := newTestEpoll(t, nil)
+
+	client, _ := newAttachedClient(t, e, Auth{}, "1.2.3.4")
+	e.Subscribe(client, []string{"btcusd.trades"}, nil)
+
+	if err := e.Remove(client); err != nil {
+		t.Fatal(err)
+	}
+
+	// A subscription racing the removal, e.g. from the read loop
+	if e.Subscribe(client, []string{"btcusd.trades"}, nil) {
+		t.Error("removed client subscribed")
+	}
+
+	if topic, ok := e.public.get("btcusd.trades"); ok && topic.len() != 0 {
+		t.Fatal("removed client left in the topic")
+	}
+
+	e.public.reap(time.Now().Add(e.Config.Rango.TopicRetention + time.Second))
+	if n := e.public.len(); n != 0 {
+		t.Errorf("%d topics left after reaping", n)
+	}
+}
@@ -1,21 +1,60 @@
 package routing
 
 import (
-	"encoding/json"
+	"errors"
 	"log"
+	"sync"
+	"time"
 
+	"github.com/gorilla/websocket"
 	"github.com/shinhagunn/websocket/pkg/message"
 )
 
+// Number of recent events a topic keeps to replay on resume.
+const topicHistorySize = 64
+
+type historyEntry struct {
+	seq      uint64
+	msg      []byte
+	prepared *websocket.PreparedMessage
+}
+
+var errTopicRemoved = errors.New("topic removed")
+
 type Topic struct {
-	send    chan<- SendMessager
+	// guards the clients and the history
+	mutex sync.Mutex
+
+	send    func(SendMessager)
 	clients map[*Client]struct{}
+
+	// stream name clients subscribed with
+	name string
+
+	// sequence number of the last broadcasted event. It starts from the
+	// creation time in microseconds, so a recreated topic never reuses the
+	// numbers of a previous one.
+	seq uint64
+
+	// ring buffer of the last broadcasted events, indexed by sequence
+	history  []historyEntry
+	buffered int
+
+	// when the last client left, zero while the topic has clients
+	idleSince time.Time
+
+	// set once the topic left its registry, which creates a new one
+	removed bool
 }
 
-func NewTopic(send chan<- SendMessager) *Topic {
+func NewTopic(send func(SendMessager), name string) *Topic {
+	now := time.Now()
 	return &Topic{
-		send:    send,
-		clients: make(map[*Client]struct{}),
+		send:      send,
+		clients:   make(map[*Client]struct{}),
+		name:      name,
+		seq:       uint64(now.UnixMicro()),
+		idleSince: now,
 	}
 }
 
@@ -37,50 +76,162 @@ func contains(list []string, el string) bool {
 	return false
 }
 
+func remove(list []string, el string) []string {
+	l := make([]string, 0, len(list))
+	for _, item := range list {
+		if item != el {
+			l = append(l, item)
+		}
+	}
+	return l
+}
+
 func (t *Topic) len() int {
+	t.mutex.Lock()
+	defer t.mutex.Unlock()
+
 	return len(t.clients)
 }
 
-func (t *Topic) broadcast(message *Event) {
-	var bodyMsg interface{}
+// framed returns the event body wrapped under its topic key. The body is
+// validated and spliced as raw JSON once, then reused for every subscriber.
+func (ev *Event) framed() ([]byte, error) {
+	if ev.payload != nil {
+		return ev.payload, nil
+	}
 
-	if err := json.Unmarshal(message.Body, &bodyMsg); err != nil {
-		log.Printf("Fail to JSON marshal: %s\n", err.Error())
-		return
+	payload, err := message.PackOutgoingRawEvent(ev.Topic, ev.Body)
+	if err != nil {
+		return nil, err
+	}
+	ev.payload = payload
+
+	return payload, nil
+}
+
+// preparedMessage returns the framed event as a websocket prepared message so
+// the frame header and compression are computed once per broadcast.
+func (ev *Event) preparedMessage() (*websocket.PreparedMessage, error) {
+	if ev.prepared != nil {
+		return ev.prepared, nil
 	}
 
-	body, err := json.Marshal(map[string]interface{}{
-		message.Topic: bodyMsg,
-	})
+	payload, err := ev.framed()
+	if err != nil {
+		return nil, err
+	}
 
+	prepared, err := websocket.NewPreparedMessage(websocket.TextMessage, payload)
 	if err != nil {
-		log.Printf("Fail to JSON marshal: %s\n", err.Error())
+		return nil, err
+	}
+	ev.prepared = prepared
+
+	return prepared, nil
+}
+
+func (t *Topic) broadcast(message *Event) {
+	prepared, err := message.preparedMessage()
+	if err != nil {
+		log.Printf("Fail to frame event: %s\n", err.Error())
 		return
 	}
 
+	t.mutex.Lock()
+	defer t.mutex.Unlock()
+
+	t.seq++
+	entry := historyEntry{
+		seq:      t.seq,
+		msg:      message.payload,
+		prepared: prepared,
+	}
+
+	if t.history == nil {
+		t.history = make([]historyEntry, topicHistorySize)
+	}
+	t.history[t.seq%topicHistorySize] = entry
+	if t.buffered < topicHistorySize {
+		t.buffered++
+	}
+
 	for client := range t.clients {
-		t.send <- NewSendMessager(client, body)
+		t.send(newEventSendMessager(client, entry, t.name))
 	}
 }
 
-func (t *Topic) broadcastRaw(msg []byte) {
-	for client := range t.clients {
-		t.send <- NewSendMessager(client, msg)
+// replay sends the buffered events following the given sequence number to a
+// single client. When events after it are no longer buffered, or it belongs
+// to a previous topic of the stream, the client is first sent a stream.reset
+// event with the sequence the replay starts after. The caller holds the
+// mutex.
+func (t *Topic) replay(c *Client, after uint64) {
+	oldest := t.seq - uint64(t.buffered)
+	if after < oldest || after > t.seq {
+		t.send(SendMessager{
+			client: c,
+			msg: eventMust("stream.reset", map[string]interface{}{
+				"stream": t.name,
+				"seq":    oldest,
+			}),
+			stream: t.name,
+			seq:    oldest,
+		})
+		after = oldest
+	}
+
+	for seq := after + 1; seq <= t.seq; seq++ {
+		t.send(newEventSendMessager(c, t.history[seq%topicHistorySize], t.name))
 	}
 }
 
-func (t *Topic) subscribe(c *Client) bool {
-	if _, ok := t.clients[c]; ok {
-		return false
+// subscribe adds the client and, when lastSeq has the topic stream, replays
+// the events it missed before any new one. It reports whether the client was
+// not subscribed yet, and fails once the topic was removed.
+func (t *Topic) subscribe(c *Client, lastSeq map[string]uint64) (bool, error) {
+	t.mutex.Lock()
+	defer t.mutex.Unlock()
+
+	if t.removed {
+		return false, errTopicRemoved
 	}
+
+	_, ok := t.clients[c]
 	t.clients[c] = struct{}{}
+	t.idleSince = time.Time{}
 
-	return true
+	if seq, resume := lastSeq[t.name]; resume {
+		t.replay(c, seq)
+	}
+
+	return !ok, nil
 }
 
+// unsubscribe removes the client, reporting whether it was subscribed.
 func (t *Topic) unsubscribe(c *Client) bool {
+	t.mutex.Lock()
+	defer t.mutex.Unlock()
+
 	_, ok := t.clients[c]
 	delete(t.clients, c)
 
+	if ok && len(t.clients) == 0 {
+		t.idleSince = time.Now()
+	}
+
 	return ok
 }
+
+// removeIfIdle marks the topic removed once it has had no clients for the
+// given time, reporting whether it did.
+func (t *Topic) removeIfIdle(now time.Time, idle time.Duration) bool {
+	t.mutex.Lock()
+	defer t.mutex.Unlock()
+
+	if len(t.clients) > 0 || now.Sub(t.idleSince) < idle {
+		return false
+	}
+
+	t.removed = true
+	return true
+}
@@ -0,0 +1,198 @@
+package routing
+
+import (
+	"io"
+	"log"
+	"os"
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+func TestMain(m *testing.M) {
+	log.SetOutput(io.Discard)
+	os.Exit(m.Run())
+}
+
+// recorder collects what topics send instead of queuing it.
+type recorder struct {
+	messages []SendMessager
+}
+
+func (r *recorder) send(m SendMessager) {
+	r.messages = append(r.messages, m)
+}
+
+func newTestClient() *Client {
+	return NewClient(NewQueueConn(1), Auth{})
+}
+
+func TestTopicBroadcastSplicesBody(t *testing.T) {
+	rec := &recorder{}
+	topic := NewTopic(rec.send, "btcusd.trades")
+	client := newTestClient()
+	topic.subscribe(client, nil)
+	seq := topic.seq
+
+	topic.broadcast(&Event{
+		Topic: "btcusd.trades",
+		Body:  []byte(`{"price":"42.5"}`),
+	})
+
+	if len(rec.messages) != 1 {
+		t.Fatalf("sent %d messages, want 1", len(rec.messages))
+	}
+
+	m := rec.messages[0]
+	if string(m.msg) != `{"btcusd.trades":{"price":"42.5"}}` {
+		t.Errorf("sent %s", m.msg)
+	}
+	if m.client != client || m.stream != "btcusd.trades" || m.seq != seq+1 || m.prepared == nil {
+		t.Errorf("sent %+v", m)
+	}
+}
+
+func TestTopicBroadcastDropsInvalidJSON(t *testing.T) {
+	rec := &recorder{}
+	topic := NewTopic(rec.send, "btcusd.trades")
+	topic.subscribe(newTestClient(), nil)
+	seq := topic.seq
+
+	topic.broadcast(&Event{
+		Topic: "btcusd.trades",
+		Body:  []byte(`{"price":`),
+	})
+
+	if len(rec.messages) != 0 {
+		t.Errorf("sent %d messages for an invalid body", len(rec.messages))
+	}
+	if topic.seq != seq || topic.buffered != 0 {
+		t.Errorf("invalid body recorded with seq %d", topic.seq)
+	}
+}
+
+func BenchmarkTopicBroadcast(b *testing.B) {
+	var sent atomic.Uint64
+	topic := NewTopic(func(SendMessager) { sent.Add(1) }, "btcusd.trades")
+	for i := 0; i < 100; i++ {
+		topic.subscribe(newTestClient(), nil)
+	}
+
+	body := []byte(`{"tid":123456,"taker_type":"buy","date":1700000000,"price":"42123.45","amount":"0.01500000"}`)
+
+	b.ReportAllocs()
+	b.ResetTimer()
+	start := time.Now()
+	for i := 0; i < b.N; i++ {
+		topic.broadcast(&Event{
+			Topic: "btcusd.trades",
+			Body:  body,
+		})
+	}
+
+	b.ReportMetric(float64(b.N)/time.Since(start).Seconds(), "events/s")
+	b.ReportMetric(float64(sent.Load())/time.Since(start).Seconds(), "messages/s")
+}
+
+func newTestEvent() *Event {
+	return &Event{
+		Topic: "btcusd.trades",
+		Body:  []byte(`{"price":"42.5"}`),
+	}
+}
+
+func TestTopicReplayAfterLastClientLeft(t *testing.T) {
+	rec := &recorder{}
+	registry := newTopicRegistry(rec.send, time.Minute)
+
+	first := newTestClient()
+	registry.subscribe("btcusd.trades", "btcusd.trades", first, nil)
+	for i := 0; i < 3; i++ {
+		registry.broadcast("btcusd.trades", newTestEvent())
+	}
+	last := rec.messages[len(rec.messages)-1].seq
+
+	registry.unsubscribe("btcusd.trades", first)
+	for i := 0; i < 2; i++ {
+		if !registry.broadcast("btcusd.trades", newTestEvent()) {
+			t.Fatal("topic without clients was not retained")
+		}
+	}
+
+	rec.messages = nil
+	resumed := newTestClient()
+	registry.subscribe("btcusd.trades", "btcusd.trades", resumed, map[string]uint64{"btcusd.trades": last})
+
+	if len(rec.messages) != 2 {
+		t.Fatalf("replayed %d messages, want 2", len(rec.messages))
+	}
+	for i, m := range rec.messages {
+		if m.client != resumed || m.seq != last+uint64(i)+1 {
+			t.Errorf("replayed %+v", m)
+		}
+	}
+}
+
+func TestTopicReplayResetsRecreatedTopic(t *testing.T) {
+	rec := &recorder{}
+	registry := newTopicRegistry(rec.send, 0)
+
+	first := newTestClient()
+	registry.subscribe("btcusd.trades", "btcusd.trades", first, nil)
+	registry.broadcast("btcusd.trades", newTestEvent())
+	last := rec.messages[len(rec.messages)-1].seq
+
+	registry.unsubscribe("btcusd.trades", first)
+	if registry.len() != 0 {
+		t.Fatal("topic without clients was not removed")
+	}
+
+	time.Sleep(time.Millisecond)
+	other := newTestClient()
+	registry.subscribe("btcusd.trades", "btcusd.trades", other, nil)
+	registry.broadcast("btcusd.trades", newTestEvent())
+	current := rec.messages[len(rec.messages)-1].seq
+	if current <= last {
+		t.Fatalf("recreated topic reused sequence %d after %d", current, last)
+	}
+
+	rec.messages = nil
+	resumed := newTestClient()
+	registry.subscribe("btcusd.trades", "btcusd.trades", resumed, map[string]uint64{"btcusd.trades": last})
+
+	if len(rec.messages) != 2 {
+		t.Fatalf("sent %d messages, want a reset and 1 event", len(rec.messages))
+	}
+	if reset := rec.messages[0]; reset.seq != current-1 || reset.prepared != nil {
+		t.Errorf("reset %s with seq %d, want %d", reset.msg, reset.seq, current-1)
+	}
+	if rec.messages[1].seq != current {
+		t.Errorf("replayed seq %d, want %d", rec.messages[1].seq, current)
+	}
+}
+
+func TestTopicReplayResetsOverflowedHistory(t *testing.T) {
+	rec := &recorder{}
+	topic := NewTopic(rec.send, "btcusd.trades")
+	start := topic.seq
+
+	for i := 0; i < 2*topicHistorySize; i++ {
+		topic.broadcast(newTestEvent())
+	}
+
+	topic.subscribe(newTestClient(), map[string]uint64{"btcusd.trades": start + 1})
+
+	if len(rec.messages) != topicHistorySize+1 {
+		t.Fatalf("sent %d messages, want a reset and %d events", len(rec.messages), topicHistorySize)
+	}
+
+	oldest := topic.seq - topicHistorySize
+	if reset := rec.messages[0]; reset.seq != oldest {
+		t.Errorf("reset %s with seq %d, want %d", reset.msg, reset.seq, oldest)
+	}
+	for i, m := range rec.messages[1:] {
+		if m.seq != oldest+uint64(i)+1 {
+			t.Fatalf("replayed seq %d at %d", m.seq, i)
+		}
+	}
+}
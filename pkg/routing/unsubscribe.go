@@ -1,8 +1,8 @@
 package routing
 
 func (e *Epoll) handleUnsubscribe(req *Request) {
-	e.mutex.Lock()
-	defer e.mutex.Unlock()
+	req.client.ops.Lock()
+	defer req.client.ops.Unlock()
 
 	for _, t := range req.Streams {
 		switch {
@@ -15,111 +15,59 @@ func (e *Epoll) handleUnsubscribe(req *Request) {
 		}
 	}
 
-	e.send <- NewSendMessager(req.client, []byte(responseMust(nil, map[string]interface{}{
+	e.send(NewSendMessager(req.client, []byte(responseMust(nil, map[string]interface{}{
 		"message": "unsubscribed",
 		"streams": req.client.GetSubscriptions(),
-	})))
+	}))))
 }
 
+// unsubscribeAll removes the client from the topics of its subscriptions.
 func (e *Epoll) unsubscribeAll(client *Client) {
-	e.mutex.Lock()
-	defer e.mutex.Unlock()
+	client.ops.Lock()
+	defer client.ops.Unlock()
 
-	for t, topic := range e.PublicTopics {
-		topic.unsubscribe(client)
-		if topic.len() == 0 {
-			delete(e.PublicTopics, t)
-		}
-	}
-
-	for k, scope := range e.PrefixedTopics {
-		for t, topic := range scope {
-			topic.unsubscribe(client)
-			if topic.len() == 0 {
-				delete(scope, t)
-			}
-		}
-
-		if len(scope) == 0 {
-			delete(e.PrefixedTopics, k)
-		}
-	}
-
-	uid := client.GetAuth().UID
-	topics, ok := e.PrivateTopics[uid]
-	if !ok {
-		return
-	}
-
-	for t, topic := range topics {
-		topic.unsubscribe(client)
-		if topic.len() == 0 {
-			delete(topics, t)
+	for _, t := range client.GetSubscriptions() {
+		switch {
+		case isPrivateStream(t):
+			e.dropPrivate(client, client.GetAuth().UID, t)
+		case isPrefixedStream(t):
+			e.dropPrefixed(client, t)
+		default:
+			e.dropPublic(client, t)
 		}
 	}
-
-	if len(topics) == 0 {
-		delete(e.PrivateTopics, uid)
-	}
 }
 
 func (e *Epoll) unsubscribePublic(t string, req *Request) {
-	topic, ok := e.PublicTopics[t]
-	if ok {
-		if topic.unsubscribe(req.client) {
-			req.client.UnsubscribePublic(t)
-		}
-
-		if topic.len() == 0 {
-			delete(e.PublicTopics, t)
-		}
-	}
+	e.dropPublic(req.client, t)
 }
 
 func (e *Epoll) unsubscribePrefixed(prefixed string, req *Request) {
-	scope, t := splitPrefixedTopic(prefixed)
-	topics, ok := e.PrefixedTopics[scope]
-	if !ok {
-		return
-	}
-
-	topic, ok := topics[t]
-	if ok {
-		if topic.unsubscribe(req.client) {
-			req.client.UnsubscribePublic(t)
-		}
-
-		if topic.len() == 0 {
-			delete(topics, t)
-			e.PrefixedTopics[scope] = topics
-		}
-	}
+	e.dropPrefixed(req.client, prefixed)
 }
 
 func (e *Epoll) unsubscribePrivate(t string, req *Request) {
-	uid := req.client.GetAuth().UID
-	if uid == "" {
-		return
-	}
+	e.dropPrivate(req.client, req.client.GetAuth().UID, t)
+}
 
-	uTopics, ok := e.PrivateTopics[uid]
-	if !ok {
-		return
-	}
+// dropPublic removes the client from a public topic.
+func (e *Epoll) dropPublic(client *Client, t string) {
+	e.public.unsubscribe(t, client)
+	client.UnsubscribePublic(t)
+}
 
-	topic, ok := uTopics[t]
-	if ok {
-		if topic.unsubscribe(req.client) {
-			req.client.UnsubscribePrivate(t)
-		}
+// dropPrefixed removes the client from a prefixed topic.
+func (e *Epoll) dropPrefixed(client *Client, prefixed string) {
+	e.prefixed.unsubscribe(prefixed, client)
+	client.UnsubscribePublic(prefixed)
+}
 
-		if topic.len() == 0 {
-			delete(uTopics, t)
-		}
+// dropPrivate removes the client from a private topic of the given user.
+func (e *Epoll) dropPrivate(client *Client, uid, t string) {
+	if uid == "" {
+		return
 	}
 
-	uTopics, ok = e.PrivateTopics[uid]
-	if ok && len(uTopics) == 0 {
-		delete(e.PrivateTopics, uid)
-	}
+	e.private.unsubscribe(privateKey(uid, t), client)
+	client.UnsubscribePrivate(t)
 }
@@ -1,12 +1,14 @@
 package routing
 
 import (
+	"fmt"
 	"log"
-	"reflect"
-	"strings"
+	"net"
+	"syscall"
 
 	"github.com/gorilla/websocket"
 	"github.com/shinhagunn/websocket/pkg/message"
+	"github.com/shinhagunn/websocket/pkg/policy"
 )
 
 func getTopic(scope, stream, typ string) string {
@@ -16,12 +18,9 @@ func getTopic(scope, stream, typ string) string {
 	return stream + "." + typ
 }
 
-func splitPrefixedTopic(prefixed string) (string, string) {
-	spl := strings.Split(prefixed, ".")
-	prefix := spl[0]
-	t := strings.TrimPrefix(prefixed, prefix+".")
-
-	return prefix, t
+// privateKey is the registry key of a private topic of the user.
+func privateKey(uid, t string) string {
+	return uid + "/" + t
 }
 
 func responseMust(e error, r interface{}) string {
@@ -35,16 +34,54 @@ func responseMust(e error, r interface{}) string {
 }
 
 func isPrivateStream(s string) bool {
-	return strings.Count(s, ".") == 0
+	return policy.StreamScope(s) == policy.ScopePrivate
+}
+func isPublicStream(s string) bool {
+	return policy.StreamScope(s) == policy.ScopePublic
 }
 func isPrefixedStream(s string) bool {
-	return strings.Count(s, ".") == 2
+	return policy.StreamScope(s) == policy.ScopePrefixed
+}
+
+// netConner is implemented by connections wrapping another one, such as
+// *tls.Conn.
+type netConner interface {
+	NetConn() net.Conn
 }
 
-func websocketFD(conn *websocket.Conn) int {
-	connVal := reflect.Indirect(reflect.ValueOf(conn)).FieldByName("conn").Elem()
-	tcpConn := reflect.Indirect(connVal).FieldByName("conn")
-	fdVal := tcpConn.FieldByName("fd")
-	pfdVal := reflect.Indirect(fdVal).FieldByName("pfd")
-	return int(pfdVal.FieldByName("Sysfd").Int())
+// websocketFD returns the file descriptor of the socket under the websocket.
+func websocketFD(conn *websocket.Conn) (int, error) {
+	return connFD(conn.UnderlyingConn())
+}
+
+// connFD returns the file descriptor of the connection, unwrapping TLS and
+// other wrapping connections down to one implementing syscall.Conn.
+func connFD(netConn net.Conn) (int, error) {
+	for {
+		if sc, ok := netConn.(syscall.Conn); ok {
+			return socketFD(sc)
+		}
+
+		wrapper, ok := netConn.(netConner)
+		if !ok {
+			return -1, fmt.Errorf("no file descriptor for %T", netConn)
+		}
+		netConn = wrapper.NetConn()
+	}
+}
+
+func socketFD(sc syscall.Conn) (int, error) {
+	raw, err := sc.SyscallConn()
+	if err != nil {
+		return -1, err
+	}
+
+	fd := -1
+	if err := raw.Control(func(s uintptr) {
+		fd = int(s)
+	}); err != nil {
+		return -1, err
+	}
+
+	return fd, nil
 }
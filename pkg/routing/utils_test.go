@@ -0,0 +1,139 @@
+package routing
+
+import (
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/gorilla/websocket"
+	"golang.org/x/sys/unix"
+)
+
+// serveWebsocket dials a websocket of the server started by newServer and
+// returns the file descriptor websocketFD found for the server side of it
+// along with the port the server listens on.
+func serveWebsocket(t *testing.T, newServer func(http.Handler) *httptest.Server) (int, int) {
+	t.Helper()
+
+	type result struct {
+		fd  int
+		err error
+	}
+	results := make(chan result, 1)
+	done := make(chan struct{})
+
+	upgrader := websocket.Upgrader{}
+	srv := newServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		conn, err := upgrader.Upgrade(w, r, nil)
+		if err != nil {
+			results <- result{-1, err}
+			return
+		}
+		defer conn.Close()
+
+		fd, err := websocketFD(conn)
+		results <- result{fd, err}
+
+		// Keep the socket open until its descriptor was checked
+		<-done
+	}))
+	t.Cleanup(srv.Close)
+	t.Cleanup(func() { close(done) })
+
+	dialer := websocket.Dialer{}
+	if srv.TLS != nil {
+		dialer.TLSClientConfig = srv.Client().Transport.(*http.Transport).TLSClientConfig
+	}
+
+	url := "ws" + strings.TrimPrefix(srv.URL, "http")
+	conn, _, err := dialer.Dial(url, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { conn.Close() })
+
+	res := <-results
+	if res.err != nil {
+		t.Fatal(res.err)
+	}
+
+	return res.fd, srv.Listener.Addr().(*net.TCPAddr).Port
+}
+
+// assertSocketPort checks fd is a socket bound to the port.
+func assertSocketPort(t *testing.T, fd, port int) {
+	t.Helper()
+
+	sa, err := unix.Getsockname(fd)
+	if err != nil {
+		t.Fatalf("fd %d is not a socket: %v", fd, err)
+	}
+
+	var got int
+	switch sa := sa.(type) {
+	case *unix.SockaddrInet4:
+		got = sa.Port
+	case *unix.SockaddrInet6:
+		got = sa.Port
+	default:
+		t.Fatalf("fd %d is a %T socket", fd, sa)
+	}
+
+	if got != port {
+		t.Errorf("fd %d bound to port %d, want %d", fd, got, port)
+	}
+}
+
+func TestWebsocketFD(t *testing.T) {
+	fd, port := serveWebsocket(t, httptest.NewServer)
+	assertSocketPort(t, fd, port)
+}
+
+func TestWebsocketFDOverTLS(t *testing.T) {
+	fd, port := serveWebsocket(t, httptest.NewTLSServer)
+	assertSocketPort(t, fd, port)
+}
+
+// wrappedConn wraps a connection the way *tls.Conn does.
+type wrappedConn struct {
+	net.Conn
+}
+
+func (c wrappedConn) NetConn() net.Conn {
+	return c.Conn
+}
+
+func TestConnFDWithoutDescriptor(t *testing.T) {
+	a, b := net.Pipe()
+	defer a.Close()
+	defer b.Close()
+
+	if fd, err := connFD(a); err == nil {
+		t.Errorf("got fd %d of a pipe", fd)
+	}
+	if fd, err := connFD(wrappedConn{a}); err == nil {
+		t.Errorf("got fd %d of a wrapped pipe", fd)
+	}
+}
+
+func TestConnFDUnwraps(t *testing.T) {
+	ln, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer ln.Close()
+
+	conn, err := net.Dial("tcp", ln.Addr().String())
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer conn.Close()
+
+	fd, err := connFD(wrappedConn{wrappedConn{conn}})
+	if err != nil {
+		t.Fatal(err)
+	}
+	assertSocketPort(t, fd, conn.LocalAddr().(*net.TCPAddr).Port)
+}